@@ -1,12 +1,99 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"os"
+
 	"github.com/rs/zerolog/log"
 	"tmd/internal"
+	"tmd/internal/verify"
 )
 
+const usage = `Usage: tmd <command> [flags]
+
+Commands:
+  run        Serve the API and sync Telegram in one process (default)
+  login      Authenticate the Telegram account and exit
+  logout     Revoke the Telegram session and wipe it from storage
+  sync       Archive Telegram without serving the API (--once for a single pass)
+  serve      Serve the API without syncing
+  export     Write archived messages as JSON lines (--chat, --out)
+  migrate    Apply (up, default), revert (down --steps N) or list (status) database migrations
+  doctor     Check configuration and connectivity
+  verify     Compare stored media with the database (--checksums, --requeue-missing, --delete-orphans)
+
+Common flags:
+  --config     Path to the config file (default "config.yaml")
+  --log-level  Override logging.level from the config
+  --account    Limit run, login, logout and sync to one configured account
+`
+
 func main() {
-	if err := internal.Run(); err != nil {
+	if err := run(os.Args[1:]); err != nil {
 		log.Fatal().Err(err).Msg("Application failed")
 	}
 }
+
+func run(args []string) error {
+	command := "run"
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		command, args = args[0], args[1:]
+	}
+
+	fs := flag.NewFlagSet("tmd "+command, flag.ExitOnError)
+	fs.Usage = func() { fmt.Fprint(fs.Output(), usage) }
+
+	var opts internal.Options
+	fs.StringVar(&opts.ConfigPath, "config", "config.yaml", "path to the config file")
+	fs.StringVar(&opts.LogLevel, "log-level", "", "override logging.level from the config")
+	fs.StringVar(&opts.Account, "account", "", "limit the command to one configured account")
+
+	switch command {
+	case "run":
+		fs.Parse(args)
+		return internal.Run(opts)
+	case "login":
+		fs.Parse(args)
+		return internal.Login(opts)
+	case "logout":
+		fs.Parse(args)
+		return internal.Logout(opts)
+	case "sync":
+		once := fs.Bool("once", false, "run a single sync pass and exit")
+		fs.Parse(args)
+		return internal.Sync(opts, *once)
+	case "serve":
+		fs.Parse(args)
+		return internal.Serve(opts)
+	case "export":
+		chatID := fs.String("chat", "", "only export the chat with this id")
+		out := fs.String("out", "", "write to this file instead of stdout")
+		fs.Parse(args)
+		return internal.Export(opts, *chatID, *out)
+	case "migrate":
+		action := "up"
+		if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+			action, args = args[0], args[1:]
+		}
+		steps := fs.Int("steps", 1, "number of migrations to revert with down")
+		fs.Parse(args)
+		return internal.Migrate(opts, action, *steps)
+	case "verify":
+		var vopts verify.Options
+		fs.BoolVar(&vopts.Checksums, "checksums", false, "read every object and compare its SHA-256")
+		fs.BoolVar(&vopts.RequeueMissing, "requeue-missing", false, "download missing and corrupt media again")
+		fs.BoolVar(&vopts.DeleteOrphans, "delete-orphans", false, "delete objects no message refers to")
+		fs.Parse(args)
+		return internal.Verify(opts, vopts)
+	case "doctor":
+		fs.Parse(args)
+		return internal.Doctor(opts)
+	case "help":
+		fmt.Print(usage)
+		return nil
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("unknown command %q", command)
+	}
+}
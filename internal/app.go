@@ -0,0 +1,313 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"tmd/internal/db"
+	"tmd/internal/health"
+	"tmd/internal/login"
+	"tmd/internal/metrics"
+	"tmd/internal/session"
+	"tmd/internal/web"
+	"tmd/pkg/cfg"
+	"tmd/pkg/envelope"
+	"tmd/pkg/logger"
+	"tmd/pkg/minio"
+	"tmd/pkg/storage"
+	"tmd/pkg/tgmiddleware"
+
+	"github.com/gotd/contrib/middleware/floodwait"
+	"github.com/gotd/td/telegram"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
+)
+
+// Options are the flags shared by every command.
+type Options struct {
+	ConfigPath string
+	LogLevel   string
+	Account    string
+}
+
+func loadConfig(opts Options) (*cfg.Config, error) {
+	config, err := cfg.LoadConfig(opts.ConfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	logPath := config.Logging.Filename
+	if logPath == "" {
+		logPath = "tmd.log"
+	}
+	level := config.Logging.Level
+	if opts.LogLevel != "" {
+		level = opts.LogLevel
+	}
+	if err := logger.SetupLogger(logPath, level); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func openDB(config *cfg.Config, migrate bool) (*db.DB, error) {
+	dbConn, err := db.NewDB(config)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to connect to DB")
+		return nil, err
+	}
+	if migrate {
+		if _, err := dbConn.MigrateUp(); err != nil {
+			log.Error().Err(err).Msg("Failed to migrate DB")
+			closeDB(dbConn)
+			return nil, err
+		}
+		return dbConn, nil
+	}
+	if pending, err := dbConn.PendingMigrations(); err != nil {
+		log.Warn().Err(err).Msg("Failed to check schema migrations")
+	} else if pending > 0 {
+		log.Warn().Int("pending", pending).Msg("Database schema is out of date, run tmd migrate")
+	}
+	return dbConn, nil
+}
+
+func closeDB(dbConn *db.DB) {
+	if err := dbConn.Shutdown(); err != nil {
+		log.Error().Err(err).Msg("Failed to close DB")
+	}
+}
+
+// openStorage builds the media storage selected by storage.backend. With
+// bootstrap set it also creates a missing bucket.
+func openStorage(config *cfg.Config, bootstrap bool) (storage.Storage, error) {
+	var (
+		st  storage.Storage
+		err error
+	)
+	switch config.Storage.Backend {
+	case "local":
+		st, err = storage.NewLocal(config.Download.BaseDir)
+	case "s3":
+		st, err = minio.New(minio.Options{
+			Endpoint:     config.S3.Endpoint,
+			Region:       config.S3.Region,
+			AccessKey:    config.S3.AccessKey,
+			SecretKey:    config.S3.SecretKey,
+			Bucket:       config.S3.Bucket,
+			BasePath:     config.S3.BasePath,
+			UseSSL:       !config.S3.DisableSSL,
+			BucketLookup: config.S3.BucketLookup,
+			StorageClass: config.S3.StorageClass,
+			SSE:          config.S3.SSE,
+			KMSKeyID:     config.S3.KMSKeyID,
+			Scheme:       "s3",
+			Versioning:   config.S3.Versioning,
+			ObjectLock:   config.S3.ObjectLock,
+		})
+	default:
+		st, err = minio.New(minio.Options{
+			Endpoint:   config.Minio.Endpoint,
+			AccessKey:  config.Minio.AccessKey,
+			SecretKey:  config.Minio.SecretKey,
+			Bucket:     config.Minio.Bucket,
+			BasePath:   config.Minio.BasePath,
+			UseSSL:     config.Minio.UseSSL,
+			Versioning: config.Minio.Versioning,
+			ObjectLock: config.Minio.ObjectLock,
+		})
+	}
+	if err != nil {
+		log.Error().Err(err).Str("backend", config.Storage.Backend).Msg("Failed to create storage")
+		return nil, err
+	}
+
+	// Media encrypted earlier stays readable as long as the key is
+	// configured, even after encryption.media is turned off again.
+	if config.Encryption.MasterKey != "" || config.Encryption.MasterKeyFile != "" {
+		key, err := envelope.LoadMasterKey(config.Encryption.MasterKey, config.Encryption.MasterKeyFile)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to load master key")
+			return nil, err
+		}
+		st = storage.NewEncrypted(st, key, config.Encryption.Media)
+	}
+
+	if b, ok := st.(storage.Bootstrapper); ok && bootstrap {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := b.Bootstrap(ctx); err != nil {
+			log.Error().Err(err).Msg("Failed to bootstrap storage")
+			return nil, err
+		}
+	}
+	return st, nil
+}
+
+// rateLimiters are shared by the clients of every account, so the configured
+// rates cap the whole process rather than each account.
+type rateLimiters struct {
+	requests  *rate.Limiter
+	downloads *rate.Limiter
+}
+
+func newRateLimiters(config *cfg.Config) *rateLimiters {
+	return &rateLimiters{
+		requests:  rate.NewLimiter(rate.Limit(config.Telegram.RateLimit.Requests), config.Telegram.RateLimit.Burst),
+		downloads: rate.NewLimiter(rate.Limit(config.Telegram.RateLimit.Downloads), config.Telegram.RateLimit.DownloadBurst),
+	}
+}
+
+func newTelegramClient(
+	config *cfg.Config,
+	limiters *rateLimiters,
+	storage session.Storage,
+	handler telegram.UpdateHandler,
+) *telegram.Client {
+	// Flood waits are retried outside the rate limiter so every retry waits
+	// for a token as well. Longer waits, and requests still failing after
+	// max_retries, are returned to the fetcher. The waiter retries forever
+	// when given 0, so it is left out when retries are turned off.
+	var middlewares []telegram.Middleware
+	if maxRetries := *config.Telegram.FloodWait.MaxRetries; maxRetries > 0 {
+		middlewares = append(middlewares, floodwait.NewSimpleWaiter().
+			WithMaxRetries(uint(maxRetries)).
+			WithMaxWait(config.Telegram.FloodWait.MaxWait))
+	}
+	middlewares = append(middlewares,
+		tgmiddleware.RateLimit(limiters.requests, limiters.downloads),
+		tgmiddleware.Instrument(metrics.RPCDuration, metrics.FloodWaits),
+	)
+
+	return telegram.NewClient(
+		config.Telegram.ApiID,
+		config.Telegram.ApiHash,
+		telegram.Options{
+			SessionStorage: storage,
+			UpdateHandler:  handler,
+			Middlewares:    middlewares,
+		},
+	)
+}
+
+// selectAccounts returns the account called name, or every configured account
+// when name is empty.
+func selectAccounts(config *cfg.Config, name string) ([]cfg.TelegramAccount, error) {
+	if name == "" {
+		return config.Telegram.Accounts, nil
+	}
+	account, err := config.Account(name)
+	if err != nil {
+		return nil, err
+	}
+	return []cfg.TelegramAccount{account}, nil
+}
+
+// newLoginBroker returns a broker when login input or the QR code should be
+// available over HTTP, and nil otherwise.
+func newLoginBroker(config *cfg.Config) *login.Broker {
+	if config.Telegram.Prompt != "web" && config.Telegram.LoginMethod != "qr" {
+		return nil
+	}
+	return login.NewBroker()
+}
+
+// openSessionStorage is used by commands that otherwise don't need the
+// database. The returned function releases the connection, if any.
+func openSessionStorage(config *cfg.Config, account cfg.TelegramAccount) (session.Storage, func(), error) {
+	if config.Telegram.Session.Backend != session.BackendDatabase {
+		storage, err := session.New(config, account, nil)
+		return storage, func() {}, err
+	}
+
+	dbConn, err := openDB(config, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	storage, err := session.New(config, account, dbConn)
+	if err != nil {
+		closeDB(dbConn)
+		return nil, nil, err
+	}
+	return storage, func() { closeDB(dbConn) }, nil
+}
+
+// startHTTPServer serves the API in the background. A listener failure calls
+// stop so the rest of the process shuts down as well.
+func startHTTPServer(
+	config *cfg.Config,
+	dbConn *db.DB,
+	st storage.Storage,
+	broker *login.Broker,
+	tracker *health.Tracker,
+	stop context.CancelFunc,
+) (*http.Server, error) {
+	handler := web.NewHandler(dbConn, st, broker, tracker, config)
+	router, err := web.SetupRouter(handler, config)
+	if err != nil {
+		return nil, err
+	}
+	return serveHTTP(config, config.Web.Addr, router, stop), nil
+}
+
+// startMetricsServer serves the probes and metrics on metrics.addr for
+// commands without the API. The returned function stops the server.
+func startMetricsServer(
+	config *cfg.Config,
+	dbConn *db.DB,
+	st storage.Storage,
+	tracker *health.Tracker,
+	stop context.CancelFunc,
+) func() {
+	handler := web.NewHandler(dbConn, st, nil, tracker, config)
+	srv := serveHTTP(config, config.Metrics.Addr, web.SetupMetricsRouter(handler), stop)
+	return func() { shutdownHTTP(srv, config.Web.ShutdownTimeout) }
+}
+
+// startLoginServer serves only the web login flow, or nothing when broker is
+// nil. The returned function stops the server.
+func startLoginServer(config *cfg.Config, broker *login.Broker, stop context.CancelFunc) (func(), error) {
+	if broker == nil {
+		return func() {}, nil
+	}
+	router, err := web.SetupLoginRouter(broker, config)
+	if err != nil {
+		return nil, err
+	}
+	srv := serveHTTP(config, config.Web.Addr, router, stop)
+	return func() { shutdownHTTP(srv, config.Web.ShutdownTimeout) }, nil
+}
+
+func serveHTTP(config *cfg.Config, addr string, handler http.Handler, stop context.CancelFunc) *http.Server {
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: handler,
+	}
+	go func() {
+		log.Info().Str("addr", addr).Msg("Starting HTTP server")
+
+		var err error
+		if config.Web.TLSCert != "" {
+			err = srv.ListenAndServeTLS(config.Web.TLSCert, config.Web.TLSKey)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Msg("Failed to run HTTP server")
+			stop()
+		}
+	}()
+	return srv
+}
+
+func shutdownHTTP(srv *http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to shut down HTTP server gracefully")
+		return
+	}
+	log.Info().Msg("HTTP server stopped")
+}
@@ -7,12 +7,14 @@ import (
 	"os"
 	"strings"
 
+	"tmd/internal/login"
 	"tmd/pkg/cfg"
 	"tmd/pkg/errors"
 
 	ghf "github.com/go-faster/errors"
 	"github.com/gotd/td/telegram"
 	"github.com/gotd/td/telegram/auth"
+	"github.com/gotd/td/telegram/auth/qrlogin"
 	"github.com/gotd/td/tg"
 	"github.com/rs/zerolog/log"
 )
@@ -37,24 +39,44 @@ func (f *flowClient) SignUp(ctx context.Context, s auth.SignUp) (*tg.AuthAuthori
 	return f.client.Auth().SignUp(ctx, s)
 }
 
+// userAuthenticator answers the login flow from the config, then from the
+// web login page when telegram.prompt is web and from stdin otherwise. The
+// broker alone doesn't decide this: a QR login has one to show the code even
+// when input comes from stdin.
 type userAuthenticator struct {
-	phone    string
-	password string
+	phone     string
+	password  string
+	broker    *login.Broker
+	webPrompt bool
 }
 
 func (u *userAuthenticator) Phone(ctx context.Context) (string, error) {
-	if u.phone == "" {
-		return "", errors.ErrNumberNotSet
+	if u.phone != "" {
+		return u.phone, nil
 	}
-	return u.phone, nil
+	if u.webPrompt {
+		return u.broker.WaitPhone(ctx)
+	}
+	return "", errors.ErrNumberNotSet
 }
 
+// Password is only called once Telegram reports that the account has 2FA
+// enabled, so an unset password is asked for instead of failing.
 func (u *userAuthenticator) Password(ctx context.Context) (string, error) {
-	return u.password, nil
+	if u.password != "" {
+		return u.password, nil
+	}
+	if u.webPrompt {
+		return u.broker.WaitPassword(ctx)
+	}
+	return promptIn("Enter your 2FA password: ", errors.ErrPasswordEmpty)
 }
 
 func (u *userAuthenticator) Code(ctx context.Context, sentCode *tg.AuthSentCode) (string, error) {
-	return promptIn("Enter the code you received from Telegram: ")
+	if u.webPrompt {
+		return u.broker.WaitCode(ctx)
+	}
+	return promptIn("Enter the code you received from Telegram: ", errors.ErrCodeEmpty)
 }
 
 func (u *userAuthenticator) AcceptTermsOfService(ctx context.Context, tos tg.HelpTermsOfService) error {
@@ -65,7 +87,7 @@ func (u *userAuthenticator) SignUp(ctx context.Context) (auth.UserInfo, error) {
 	return auth.UserInfo{}, ghf.New("sign-up is not supported")
 }
 
-func promptIn(prompt string) (string, error) {
+func promptIn(prompt string, errEmpty error) (string, error) {
 	fmt.Print(prompt)
 	r := bufio.NewReader(os.Stdin)
 	text, err := r.ReadString('\n')
@@ -74,28 +96,94 @@ func promptIn(prompt string) (string, error) {
 	}
 	s := strings.TrimSpace(text)
 	if s == "" {
-		return "", errors.ErrCodeEmpty
+		return "", errEmpty
 	}
 	return s, nil
 }
 
-func EnsureAuth(ctx context.Context, client *telegram.Client, config *cfg.Config) error {
+// Authenticator runs the login flow selected by telegram.login_method,
+// reading input from stdin or, with telegram.prompt web, from the web login
+// page.
+type Authenticator struct {
+	config   *cfg.Config
+	account  cfg.TelegramAccount
+	broker   *login.Broker
+	loggedIn qrlogin.LoggedIn
+}
+
+// NewAuthenticator registers the QR login handler on dispatcher, so it must
+// be called before the client is started. broker may be nil.
+func NewAuthenticator(config *cfg.Config, account cfg.TelegramAccount, broker *login.Broker, dispatcher tg.UpdateDispatcher) *Authenticator {
+	return &Authenticator{
+		config:   config,
+		account:  account,
+		broker:   broker,
+		loggedIn: qrlogin.OnLoginToken(dispatcher),
+	}
+}
+
+func (a *Authenticator) EnsureAuth(ctx context.Context, client *telegram.Client) error {
 	s, err := client.Auth().Status(ctx)
 	if err != nil {
-		return ghf.Wrap(err, "failed to get auth status")
+		return ghf.Wrap(errors.HandleTGError(err), "failed to get auth status")
 	}
 	if s.Authorized {
-		log.Info().Msg("User is already authorized; no further action needed.")
+		log.Info().Str("account", a.account.Name).Msg("User is already authorized; no further action needed.")
 		return nil
 	}
-	if config.Telegram.PhoneNumber == "" {
-		return errors.ErrNumberNotSet
+
+	if a.account.IsBot() {
+		if _, err := client.Auth().Bot(ctx, a.account.BotToken); err != nil {
+			return ghf.Wrap(errors.HandleTGError(err), "bot login failed")
+		}
+		log.Info().Str("account", a.account.Name).Msg("Successfully authenticated as a bot.")
+		return nil
 	}
+
+	if a.broker != nil {
+		release, err := a.broker.Acquire(ctx, a.account.Name)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+
+	if a.config.Telegram.LoginMethod == "qr" {
+		err = a.qrLogin(ctx, client)
+	} else {
+		err = a.codeLogin(ctx, client)
+	}
+	if err != nil {
+		if wait, ok := errors.AsFloodWait(err); ok {
+			log.Error().Dur("retry_after", wait).Msg("Telegram rate-limited the login; retry later")
+		}
+		if a.broker != nil {
+			a.broker.SetFailed(err)
+		}
+		return err
+	}
+	a.setAuthorized()
+	return nil
+}
+
+func (a *Authenticator) setAuthorized() {
+	if a.broker != nil {
+		a.broker.SetAuthorized()
+	}
+}
+
+func (a *Authenticator) userAuthenticator() *userAuthenticator {
+	return &userAuthenticator{
+		phone:     a.account.PhoneNumber,
+		password:  a.account.Password,
+		broker:    a.broker,
+		webPrompt: a.config.Telegram.Prompt == "web" && a.broker != nil,
+	}
+}
+
+func (a *Authenticator) codeLogin(ctx context.Context, client *telegram.Client) error {
 	flow := auth.NewFlow(
-		&userAuthenticator{
-			phone:    config.Telegram.PhoneNumber,
-			password: config.Telegram.Password,
-		},
+		a.userAuthenticator(),
 		auth.SendCodeOptions{
 			AllowFlashCall: true,
 			CurrentNumber:  true,
@@ -104,8 +192,144 @@ func EnsureAuth(ctx context.Context, client *telegram.Client, config *cfg.Config
 	)
 	f := &flowClient{client: client}
 	if err := flow.Run(ctx, f); err != nil {
-		return ghf.Wrap(err, "auth flow failed")
+		return ghf.Wrap(errors.HandleTGError(err), "auth flow failed")
+	}
+	log.Info().Str("account", a.account.Name).Msg("Successfully authenticated via AuthFlow.")
+	return nil
+}
+
+func (a *Authenticator) qrLogin(ctx context.Context, client *telegram.Client) error {
+	_, err := client.QR().Auth(ctx, a.loggedIn, func(ctx context.Context, token qrlogin.Token) error {
+		if a.broker != nil {
+			a.broker.ShowQR(token.URL(), token.Expires())
+		}
+		log.Info().Time("expires", token.Expires()).Msg("Waiting for QR login")
+		fmt.Printf("Scan this QR code with account %q in Telegram (Settings > Devices > Link Desktop Device):\n", a.account.Name)
+		return login.PrintQR(os.Stdout, token.URL())
+	})
+	if errors.Is2FAError(err) {
+		password, err := a.userAuthenticator().Password(ctx)
+		if err != nil {
+			return err
+		}
+		if _, err := client.Auth().Password(ctx, password); err != nil {
+			return ghf.Wrap(errors.HandleTGError(err), "2FA password check failed")
+		}
+	} else if err != nil {
+		return ghf.Wrap(errors.HandleTGError(err), "QR login failed")
+	}
+	log.Info().Str("account", a.account.Name).Msg("Successfully authenticated via QR code.")
+	return nil
+}
+
+// Login authenticates the selected accounts one after another and exits.
+func Login(opts Options) error {
+	config, err := loadConfig(opts)
+	if err != nil {
+		return err
+	}
+
+	accounts, err := selectAccounts(config, opts.Account)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	broker := newLoginBroker(config)
+	stopLogin, err := startLoginServer(config, broker, stop)
+	if err != nil {
+		return err
+	}
+	defer stopLogin()
+
+	limiters := newRateLimiters(config)
+	for _, account := range accounts {
+		if err := loginAccount(ctx, config, limiters, account, broker); err != nil {
+			return fmt.Errorf("account %q: %w", account.Name, err)
+		}
+	}
+	return nil
+}
+
+func loginAccount(
+	ctx context.Context,
+	config *cfg.Config,
+	limiters *rateLimiters,
+	account cfg.TelegramAccount,
+	broker *login.Broker,
+) error {
+	storage, release, err := openSessionStorage(config, account)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	dispatcher := tg.NewUpdateDispatcher()
+	authenticator := NewAuthenticator(config, account, broker, dispatcher)
+	client := newTelegramClient(config, limiters, storage, dispatcher)
+	return client.Run(ctx, func(ctx context.Context) error {
+		if err := authenticator.EnsureAuth(ctx, client); err != nil {
+			return err
+		}
+		self, err := client.Self(ctx)
+		if err != nil {
+			return ghf.Wrap(errors.HandleTGError(err), "failed to get self user")
+		}
+		fmt.Printf("Account %q logged in as %s (id %d)\n", account.Name, self.Username, self.ID)
+		return nil
+	})
+}
+
+// Logout revokes the Telegram authorization of one account and wipes its
+// stored session.
+func Logout(opts Options) error {
+	config, err := loadConfig(opts)
+	if err != nil {
+		return err
+	}
+
+	accounts, err := selectAccounts(config, opts.Account)
+	if err != nil {
+		return err
+	}
+	if len(accounts) != 1 {
+		return fmt.Errorf("several accounts are configured; choose one with --account")
+	}
+	account := accounts[0]
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	storage, release, err := openSessionStorage(config, account)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	client := newTelegramClient(config, newRateLimiters(config), storage, tg.NewUpdateDispatcher())
+	err = client.Run(ctx, func(ctx context.Context) error {
+		s, err := client.Auth().Status(ctx)
+		if err != nil {
+			return ghf.Wrap(errors.HandleTGError(err), "failed to get auth status")
+		}
+		if !s.Authorized {
+			log.Info().Msg("Session is not authorized; only wiping local state")
+			return nil
+		}
+		if _, err := client.API().AuthLogOut(ctx); err != nil {
+			return ghf.Wrap(errors.HandleTGError(err), "failed to log out")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := storage.Delete(context.Background()); err != nil {
+		return err
 	}
-	log.Info().Msg("Successfully authenticated via AuthFlow.")
+	fmt.Printf("Account %q logged out and its stored session removed\n", account.Name)
 	return nil
 }
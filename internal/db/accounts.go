@@ -0,0 +1,42 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// EnsureAccount returns the account with the given name, creating it on first
+// use.
+func (db *DB) EnsureAccount(name string) (*Account, error) {
+	account := Account{Name: name}
+	if err := db.Conn.Where("name = ?", name).FirstOrCreate(&account).Error; err != nil {
+		return nil, fmt.Errorf("failed to ensure account %q: %w", name, err)
+	}
+	return &account, nil
+}
+
+// UpdateAccountIdentity records which Telegram user the account logged in as.
+func (db *DB) UpdateAccountIdentity(account *Account, telegramUserID int64, username string) error {
+	account.TelegramUserID = telegramUserID
+	account.Username = username
+	if err := db.Conn.Model(account).Updates(map[string]any{
+		"telegram_user_id": telegramUserID,
+		"username":         username,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to update account %q: %w", account.Name, err)
+	}
+	return nil
+}
+
+// AdoptLegacyMessages assigns messages archived before accounts existed to
+// accountID.
+func (db *DB) AdoptLegacyMessages(accountID uuid.UUID) error {
+	res := db.Conn.Model(&Message{}).
+		Where("account_id IS NULL").
+		Update("account_id", accountID)
+	if res.Error != nil {
+		return fmt.Errorf("failed to adopt legacy messages: %w", res.Error)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+
+	"gorm.io/gorm/schema"
+	"tmd/pkg/envelope"
+)
+
+// contentKey opens sealed message content and, with sealContent set by
+// encryption.content, seals new content. gorm serializers are registered
+// globally, so NewDB stores them here.
+var (
+	contentKey  atomic.Pointer[envelope.MasterKey]
+	sealContent atomic.Bool
+)
+
+func init() {
+	schema.RegisterSerializer("content", contentSerializer{})
+}
+
+// contentSerializer encrypts Message.Content when sealContent is set.
+// Plaintext written before encryption was enabled is read as it is.
+type contentSerializer struct{}
+
+func (contentSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var content string
+	switch v := dbValue.(type) {
+	case nil:
+	case []byte:
+		content = string(v)
+	case string:
+		content = v
+	default:
+		return fmt.Errorf("unexpected content type %T", dbValue)
+	}
+
+	if envelope.IsSealed(content) {
+		key := contentKey.Load()
+		if key == nil {
+			return errors.New("message content is encrypted but no encryption.master_key is configured")
+		}
+		var err error
+		if content, err = key.OpenString(content); err != nil {
+			return fmt.Errorf("decrypt message content: %w", err)
+		}
+	}
+	field.ReflectValueOf(ctx, dst).SetString(content)
+	return nil
+}
+
+func (contentSerializer) Value(_ context.Context, _ *schema.Field, _ reflect.Value, fieldValue interface{}) (interface{}, error) {
+	content, _ := fieldValue.(string)
+	key := contentKey.Load()
+	if key == nil || !sealContent.Load() || content == "" {
+		return content, nil
+	}
+	return key.SealString(content)
+}
@@ -1,35 +1,53 @@
 package db
 
 import (
+	"context"
 	"fmt"
+	"github.com/glebarez/sqlite"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 	"gorm.io/gorm/schema"
 	"tmd/pkg/cfg"
+	"tmd/pkg/envelope"
 )
 
 type DB struct {
-	Conn *gorm.DB
+	Conn    *gorm.DB
+	Dialect string
+}
+
+var logLevels = map[string]logger.LogLevel{
+	"silent": logger.Silent,
+	"error":  logger.Error,
+	"warn":   logger.Warn,
+	"info":   logger.Info,
 }
 
 func NewDB(configuration *cfg.Config) (*DB, error) {
-	if configuration.Database.Dialect != "postgres" {
+	var dialector gorm.Dialector
+	switch configuration.Database.Dialect {
+	case "postgres":
+		dialector = postgres.Open(fmt.Sprintf(
+			"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+			configuration.Database.Host,
+			configuration.Database.Port,
+			configuration.Database.User,
+			configuration.Database.Password,
+			configuration.Database.DBName,
+			configuration.Database.SSLMode,
+		))
+	case "sqlite":
+		// Foreign keys are off by default in SQLite; WAL and a busy timeout
+		// let the API read while a sync is writing.
+		dialector = sqlite.Open(configuration.Database.Path +
+			"?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	default:
 		return nil, fmt.Errorf("unsupported database dialect: %s", configuration.Database.Dialect)
 	}
 
-	dsn := fmt.Sprintf(
-		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
-		configuration.Database.Host,
-		configuration.Database.Port,
-		configuration.Database.User,
-		configuration.Database.Password,
-		configuration.Database.DBName,
-		configuration.Database.SSLMode,
-	)
-
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
+	db, err := gorm.Open(dialector, &gorm.Config{
+		Logger: logger.Default.LogMode(logLevels[configuration.Database.LogLevel]),
 		NamingStrategy: schema.NamingStrategy{
 			SingularTable: false,
 		},
@@ -39,11 +57,36 @@ func NewDB(configuration *cfg.Config) (*DB, error) {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
-	if err := db.AutoMigrate(&User{}, &Chat{}, &ChatUser{}, &Message{}); err != nil {
-		return nil, fmt.Errorf("failed to auto migrate users: %w", err)
+	// Content stays readable as long as the key is configured, even after
+	// encryption.content is turned off again.
+	if configuration.Encryption.MasterKey != "" || configuration.Encryption.MasterKeyFile != "" {
+		key, err := envelope.LoadMasterKey(configuration.Encryption.MasterKey, configuration.Encryption.MasterKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		contentKey.Store(key)
+		sealContent.Store(configuration.Encryption.Content)
 	}
 
-	return &DB{Conn: db}, nil
+	if configuration.Database.Dialect == "sqlite" {
+		// One connection avoids SQLITE_BUSY between the dialog workers of a
+		// single process.
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		sqlDB.SetMaxOpenConns(1)
+	}
+
+	return &DB{Conn: db, Dialect: configuration.Database.Dialect}, nil
+}
+
+func (db *DB) Ping(ctx context.Context) error {
+	sqlDB, err := db.Conn.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
 }
 
 func (db *DB) Shutdown() error {
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// Chat jobs run ahead of full passes.
+const (
+	PriorityFullSync = 0
+	PriorityChatSync = 10
+)
+
+// EnqueueSyncJob adds job to the queue.
+func (db *DB) EnqueueSyncJob(job *SyncJob) error {
+	job.Status = JobQueued
+	if err := db.Conn.Create(job).Error; err != nil {
+		return fmt.Errorf("failed to enqueue sync job: %w", err)
+	}
+	return nil
+}
+
+// ClaimSyncJob marks the most urgent queued job for accountID as running and
+// returns it, or nil when there is none. With chatOnly set, full-pass jobs are
+// left in the queue. The status check in the update makes the claim safe when
+// several processes poll the same queue.
+func (db *DB) ClaimSyncJob(accountID uuid.UUID, chatOnly bool) (*SyncJob, error) {
+	for {
+		query := db.Conn.
+			Where("status = ?", JobQueued).
+			Where("account_id = ? OR account_id IS NULL", accountID)
+		if chatOnly {
+			query = query.Where("chat_id IS NOT NULL")
+		}
+
+		var job SyncJob
+		err := query.Order("priority DESC, created_at").First(&job).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to find queued sync job: %w", err)
+		}
+
+		now := time.Now()
+		res := db.Conn.Model(&SyncJob{}).
+			Where("id = ? AND status = ?", job.ID, JobQueued).
+			Updates(map[string]any{
+				"status":     JobRunning,
+				"account_id": accountID,
+				"started_at": now,
+			})
+		if res.Error != nil {
+			return nil, fmt.Errorf("failed to claim sync job: %w", res.Error)
+		}
+		if res.RowsAffected == 0 {
+			// Another process claimed it first.
+			continue
+		}
+		job.Status = JobRunning
+		job.AccountID = &accountID
+		job.StartedAt = &now
+		return &job, nil
+	}
+}
+
+// FinishSyncJob records the outcome of a running job.
+func (db *DB) FinishSyncJob(job *SyncJob, jobErr error) error {
+	now := time.Now()
+	job.FinishedAt = &now
+	job.Status = JobSucceeded
+	job.Error = ""
+	if jobErr != nil {
+		job.Status = JobFailed
+		job.Error = jobErr.Error()
+	}
+	if err := db.Conn.Model(job).Updates(map[string]any{
+		"status":      job.Status,
+		"error":       job.Error,
+		"finished_at": now,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to finish sync job: %w", err)
+	}
+	return nil
+}
+
+// RequeueSyncJobs puts jobs that accountID was running back in the queue.
+// Called on startup, as they can only be left running by a crashed process.
+func (db *DB) RequeueSyncJobs(accountID uuid.UUID) error {
+	if err := db.Conn.Model(&SyncJob{}).
+		Where("status = ? AND account_id = ?", JobRunning, accountID).
+		Updates(map[string]any{"status": JobQueued, "started_at": nil}).Error; err != nil {
+		return fmt.Errorf("failed to requeue sync jobs: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestClaimSyncJob(t *testing.T) {
+	database := newTestDB(t)
+	account, other := uuid.New(), uuid.New()
+	chat := uuid.New()
+
+	enqueue := func(job *SyncJob) *SyncJob {
+		t.Helper()
+		if err := database.EnqueueSyncJob(job); err != nil {
+			t.Fatal(err)
+		}
+		// Claims are ordered by creation time within a priority.
+		time.Sleep(time.Millisecond)
+		return job
+	}
+	full := enqueue(&SyncJob{Priority: PriorityFullSync})
+	chatJob := enqueue(&SyncJob{ChatID: &chat, Priority: PriorityChatSync})
+	otherJob := enqueue(&SyncJob{ChatID: &chat, AccountID: &other, Priority: PriorityChatSync})
+	ownChatJob := enqueue(&SyncJob{ChatID: &chat, AccountID: &account, Priority: PriorityChatSync})
+
+	claim := func(chatOnly bool) *SyncJob {
+		t.Helper()
+		job, err := database.ClaimSyncJob(account, chatOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return job
+	}
+
+	// Chat jobs come first, oldest first; other accounts' jobs are skipped.
+	for _, want := range []*SyncJob{chatJob, ownChatJob} {
+		job := claim(true)
+		if job == nil || job.ID != want.ID {
+			t.Fatalf("claimed %+v, want job %s", job, want.ID)
+		}
+		if job.Status != JobRunning || job.AccountID == nil || *job.AccountID != account || job.StartedAt == nil {
+			t.Errorf("claimed job = %+v", job)
+		}
+	}
+	if job := claim(true); job != nil {
+		t.Fatalf("claimed full-pass job %s with chatOnly set", job.ID)
+	}
+	if job := claim(false); job == nil || job.ID != full.ID {
+		t.Fatalf("claimed %+v, want the full pass", job)
+	}
+	if job := claim(false); job != nil {
+		t.Fatalf("claimed %s from an empty queue", job.ID)
+	}
+
+	load := func(id uuid.UUID) SyncJob {
+		t.Helper()
+		var job SyncJob
+		if err := database.Conn.First(&job, "id = ?", id).Error; err != nil {
+			t.Fatal(err)
+		}
+		return job
+	}
+	if stored := load(chatJob.ID); stored.Status != JobRunning || stored.AccountID == nil || *stored.AccountID != account {
+		t.Errorf("stored claim = %+v", stored)
+	}
+	if stored := load(otherJob.ID); stored.Status != JobQueued {
+		t.Errorf("job of another account = %+v", stored)
+	}
+
+	// Jobs left running by a crash go back to the queue.
+	if err := database.FinishSyncJob(chatJob, errors.New("flood wait")); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.RequeueSyncJobs(account); err != nil {
+		t.Fatal(err)
+	}
+	if job := claim(false); job == nil || job.ID != ownChatJob.ID {
+		t.Fatalf("claimed %+v after requeue, want job %s", job, ownChatJob.ID)
+	}
+	if stored := load(chatJob.ID); stored.Status != JobFailed || stored.Error != "flood wait" || stored.FinishedAt == nil {
+		t.Errorf("finished job = %+v", stored)
+	}
+}
@@ -0,0 +1,45 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// RequeueMedia clears the media URL of the given messages, so the next
+// retry pass of the account fetches just these messages again and downloads
+// their media.
+func (db *DB) RequeueMedia(chatID, accountID uuid.UUID, messageIDs []int) error {
+	if len(messageIDs) == 0 {
+		return nil
+	}
+	if err := db.Conn.Model(&Message{}).
+		Where("chat_id = ? AND account_id = ? AND message_id IN ?", chatID, accountID, messageIDs).
+		Update("media_url", "").Error; err != nil {
+		return fmt.Errorf("failed to requeue media: %w", err)
+	}
+	return nil
+}
+
+// MissingMedia returns, by chat, the IDs of the account's messages that have
+// media but no stored object, because the download failed, was dropped from
+// the queue or was cancelled, or because RequeueMedia cleared it.
+func (db *DB) MissingMedia(accountID uuid.UUID) (map[uuid.UUID][]int, error) {
+	var rows []struct {
+		ChatID    uuid.UUID
+		MessageID int
+	}
+	if err := db.Conn.Model(&Message{}).
+		Select("chat_id", "message_id").
+		Where("account_id = ? AND media_url = '' AND message_type <> 'text'", accountID).
+		Order("chat_id, message_id").
+		Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to look up missing media: %w", err)
+	}
+
+	missing := make(map[uuid.UUID][]int)
+	for _, row := range rows {
+		missing[row.ChatID] = append(missing[row.ChatID], row.MessageID)
+	}
+	return missing, nil
+}
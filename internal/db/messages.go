@@ -0,0 +1,19 @@
+package db
+
+import "gorm.io/gorm"
+
+// DistinctMessages limits a messages query to one copy of each channel
+// message. Every account in a shared chat archives its own copy; the one
+// whose media was uploaded is preferred, then the lowest id so the choice is
+// stable. Only channels share message IDs between accounts, so other chats
+// keep every copy.
+func DistinctMessages(query *gorm.DB) *gorm.DB {
+	return query.Where(`NOT EXISTS (
+		SELECT 1 FROM messages other
+		JOIN chats ON chats.id = other.chat_id AND chats.peer_type = 'channel'
+		WHERE other.chat_id = messages.chat_id
+			AND other.message_id = messages.message_id
+			AND ((other.media_url <> '') > (messages.media_url <> '')
+				OR ((other.media_url <> '') = (messages.media_url <> '') AND other.id < messages.id))
+	)`)
+}
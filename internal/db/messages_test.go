@@ -0,0 +1,72 @@
+package db
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestDistinctMessages(t *testing.T) {
+	database := newTestDB(t)
+	group, user := newChat(t, database)
+	channel := &Chat{TelegramID: 300, PeerType: "channel", Title: "Channel"}
+	if err := database.Conn.Create(channel).Error; err != nil {
+		t.Fatal(err)
+	}
+	alice, err := database.EnsureAccount("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := database.EnsureAccount("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, msg := range []*Message{
+		// The same ID names different messages for each account in a basic group.
+		{MessageID: 1, ChatID: group.ID, AccountID: &alice.ID, Content: "alice 1"},
+		{MessageID: 1, ChatID: group.ID, AccountID: &bob.ID, Content: "bob 1"},
+		{MessageID: 2, ChatID: group.ID, AccountID: &bob.ID, Content: "bob 2"},
+		// In a channel it is the same message; the copy with media wins.
+		{MessageID: 1, ChatID: channel.ID, AccountID: &alice.ID, Content: "post"},
+		{MessageID: 1, ChatID: channel.ID, AccountID: &bob.ID, Content: "post", MediaURL: "file://channel/1.jpg"},
+		{MessageID: 2, ChatID: channel.ID, AccountID: &alice.ID, Content: "copy"},
+		{MessageID: 2, ChatID: channel.ID, AccountID: &bob.ID, Content: "copy"},
+	} {
+		msg.UserID = user.ID
+		msg.MessageType = "text"
+		if err := database.Conn.Create(msg).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		chatID any
+		want   []string
+	}{
+		{"basic group", group.ID, []string{"alice 1", "bob 1", "bob 2"}},
+		{"channel", channel.ID, []string{"copy", "file://channel/1.jpg"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var messages []Message
+			if err := database.Conn.Where("chat_id = ?", tt.chatID).Scopes(DistinctMessages).
+				Find(&messages).Error; err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, msg := range messages {
+				if msg.MediaURL != "" {
+					got = append(got, msg.MediaURL)
+				} else {
+					got = append(got, msg.Content)
+				}
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("messages = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package db
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// Migration is one versioned schema change, read from
+// migrations/<dialect>/<version>_<name>.{up,down}.sql.
+// Migrations without a down script can't be reverted.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus tells whether a migration has been applied.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// SchemaMigration records an applied migration.
+type SchemaMigration struct {
+	Version   int    `gorm:"primaryKey;autoIncrement:false"`
+	Name      string `gorm:"size:255;not null"`
+	AppliedAt time.Time
+}
+
+// migrationLockID serialises migrations when several processes start at once.
+const migrationLockID = 7_245_193
+
+func loadMigrations(dialect string) ([]Migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for dialect %s: %w", dialect, err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		base, direction, ok := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), ".")
+		rawVersion, name, found := strings.Cut(base, "_")
+		version, err := strconv.Atoi(rawVersion)
+		if !ok || !found || err != nil || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+
+		data, err := fs.ReadFile(migrationFiles, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, exists := byVersion[version]
+		if !exists {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// MigrateUp applies every pending migration in order and returns how many
+// were applied.
+func (db *DB) MigrateUp() (int, error) {
+	migrations, err := loadMigrations(db.Dialect)
+	if err != nil {
+		return 0, err
+	}
+	if err := db.Conn.AutoMigrate(&SchemaMigration{}); err != nil {
+		return 0, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	applied := 0
+	for _, m := range migrations {
+		ran, err := db.runMigration(m, true)
+		if err != nil {
+			return applied, err
+		}
+		if ran {
+			applied++
+		}
+	}
+	return applied, nil
+}
+
+// MigrateDown reverts the last steps applied migrations and returns how many
+// were reverted.
+func (db *DB) MigrateDown(steps int) (int, error) {
+	migrations, err := loadMigrations(db.Dialect)
+	if err != nil {
+		return 0, err
+	}
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	reverted := 0
+	for i := len(migrations) - 1; i >= 0 && reverted < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if m.Down == "" {
+			return reverted, fmt.Errorf("migration %d_%s can't be reverted", m.Version, m.Name)
+		}
+		if _, err := db.runMigration(m, false); err != nil {
+			return reverted, err
+		}
+		reverted++
+	}
+	return reverted, nil
+}
+
+// MigrationStatus lists every known migration and when it was applied.
+func (db *DB) MigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := loadMigrations(db.Dialect)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		statuses[i] = MigrationStatus{Version: m.Version, Name: m.Name}
+		if at, ok := applied[m.Version]; ok {
+			statuses[i].AppliedAt = &at
+		}
+	}
+	return statuses, nil
+}
+
+// PendingMigrations returns how many migrations have not been applied yet.
+func (db *DB) PendingMigrations() (int, error) {
+	statuses, err := db.MigrationStatus()
+	if err != nil {
+		return 0, err
+	}
+	pending := 0
+	for _, s := range statuses {
+		if s.AppliedAt == nil {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+func (db *DB) appliedMigrations() (map[int]time.Time, error) {
+	applied := make(map[int]time.Time)
+	if !db.Conn.Migrator().HasTable(&SchemaMigration{}) {
+		return applied, nil
+	}
+	var rows []SchemaMigration
+	if err := db.Conn.Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	for _, row := range rows {
+		applied[row.Version] = row.AppliedAt
+	}
+	return applied, nil
+}
+
+// runMigration applies (up) or reverts m in a transaction together with its
+// schema_migrations row. It reports false when another process got there
+// first.
+func (db *DB) runMigration(m Migration, up bool) (bool, error) {
+	ran := false
+	err := db.Conn.Transaction(func(tx *gorm.DB) error {
+		// SQLite serialises writers on its own.
+		if db.Dialect == "postgres" {
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", migrationLockID).Error; err != nil {
+				return fmt.Errorf("failed to lock schema_migrations: %w", err)
+			}
+		}
+
+		var count int64
+		if err := tx.Model(&SchemaMigration{}).Where("version = ?", m.Version).Count(&count).Error; err != nil {
+			return err
+		}
+		if (count > 0) == up {
+			return nil
+		}
+
+		script := m.Up
+		if !up {
+			script = m.Down
+		}
+		if err := tx.Exec(script).Error; err != nil {
+			return err
+		}
+
+		if up {
+			err := tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+			if err != nil {
+				return err
+			}
+		} else if err := tx.Delete(&SchemaMigration{}, "version = ?", m.Version).Error; err != nil {
+			return err
+		}
+		ran = true
+		return nil
+	})
+	if err != nil {
+		direction := "apply"
+		if !up {
+			direction = "revert"
+		}
+		return false, fmt.Errorf("failed to %s migration %d_%s: %w", direction, m.Version, m.Name, err)
+	}
+	if ran {
+		log.Info().Int("version", m.Version).Str("name", m.Name).Bool("up", up).Msg("Ran schema migration")
+	}
+	return ran, nil
+}
@@ -0,0 +1,198 @@
+package db
+
+import (
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/gorm/schema"
+	"tmd/pkg/cfg"
+)
+
+// newTestDB returns a migrated SQLite database in a temporary directory.
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	var c cfg.Config
+	c.Database.Dialect = "sqlite"
+	c.Database.Path = filepath.Join(t.TempDir(), "tmd.db")
+	c.Database.LogLevel = "silent"
+	database, err := NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = database.Shutdown() })
+	if _, err := database.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	return database
+}
+
+var (
+	createTable = regexp.MustCompile(`(?s)CREATE TABLE (?:IF NOT EXISTS )?(\w+) \((.*?)\n\s*\);`)
+	addColumn   = regexp.MustCompile(`ALTER TABLE (\w+) ADD COLUMN (?:IF NOT EXISTS )?(\w+)`)
+	createIndex = regexp.MustCompile(`CREATE (?:UNIQUE )?INDEX (?:IF NOT EXISTS )?(\w+)`)
+	dropIndex   = regexp.MustCompile(`DROP INDEX (?:IF EXISTS )?(\w+)`)
+)
+
+// scriptedSchema returns the tables, with their columns, and the indexes the
+// up scripts of dialect leave behind.
+func scriptedSchema(t *testing.T, dialect string) (map[string][]string, []string) {
+	t.Helper()
+	migrations, err := loadMigrations(dialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables := make(map[string][]string)
+	indexes := make(map[string]bool)
+	for _, m := range migrations {
+		for _, match := range createTable.FindAllStringSubmatch(m.Up, -1) {
+			if _, ok := tables[match[1]]; ok {
+				continue
+			}
+			var columns []string
+			for _, line := range strings.Split(match[2], "\n") {
+				if column, _, _ := strings.Cut(strings.TrimSpace(line), " "); column != "" {
+					columns = append(columns, column)
+				}
+			}
+			tables[match[1]] = columns
+		}
+		for _, match := range addColumn.FindAllStringSubmatch(m.Up, -1) {
+			tables[match[1]] = append(tables[match[1]], match[2])
+		}
+		// Scripts drop indexes before creating their replacements.
+		for _, match := range dropIndex.FindAllStringSubmatch(m.Up, -1) {
+			delete(indexes, match[1])
+		}
+		for _, match := range createIndex.FindAllStringSubmatch(m.Up, -1) {
+			indexes[match[1]] = true
+		}
+	}
+	for _, columns := range tables {
+		sort.Strings(columns)
+	}
+	names := make([]string, 0, len(indexes))
+	for name := range indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return tables, names
+}
+
+func versions(t *testing.T, dialect string) []int {
+	t.Helper()
+	migrations, err := loadMigrations(dialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v []int
+	for _, m := range migrations {
+		v = append(v, m.Version)
+	}
+	return v
+}
+
+func TestDialectsReachTheSameSchema(t *testing.T) {
+	pgVersions, sqliteVersions := versions(t, "postgres"), versions(t, "sqlite")
+	if i := len(pgVersions) - len(sqliteVersions); i < 0 || !reflect.DeepEqual(pgVersions[i:], sqliteVersions) {
+		t.Errorf("sqlite migrations %v don't match the last postgres migrations %v", sqliteVersions, pgVersions)
+	}
+
+	pgTables, pgIndexes := scriptedSchema(t, "postgres")
+	sqliteTables, sqliteIndexes := scriptedSchema(t, "sqlite")
+	if !reflect.DeepEqual(pgTables, sqliteTables) {
+		t.Errorf("tables differ:\npostgres %v\nsqlite   %v", pgTables, sqliteTables)
+	}
+	// SQLite searches content through the messages_fts table instead.
+	pgIndexes = slices.DeleteFunc(pgIndexes, func(name string) bool { return name == "idx_messages_content_search" })
+	if !reflect.DeepEqual(pgIndexes, sqliteIndexes) {
+		t.Errorf("indexes differ:\npostgres %v\nsqlite   %v", pgIndexes, sqliteIndexes)
+	}
+}
+
+func TestSQLiteSchemaMatchesModels(t *testing.T) {
+	database := newTestDB(t)
+	models := []any{
+		&Account{}, &Chat{}, &User{}, &ChatUser{}, &Message{}, &SyncState{},
+		&SyncJob{}, &UpdateState{}, &UpdateChannelState{}, &Session{}, &AuditEvent{},
+	}
+	tables, indexes := scriptedSchema(t, "sqlite")
+	for _, model := range models {
+		s, err := schema.Parse(model, &sync.Map{}, schema.NamingStrategy{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		columnTypes, err := database.Conn.Migrator().ColumnTypes(s.Table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var columns []string
+		for _, c := range columnTypes {
+			columns = append(columns, c.Name())
+		}
+		want := append([]string(nil), s.DBNames...)
+		sort.Strings(columns)
+		sort.Strings(want)
+		if !reflect.DeepEqual(columns, want) {
+			t.Errorf("table %s has columns %v, model has %v", s.Table, columns, want)
+		}
+		if !reflect.DeepEqual(tables[s.Table], want) {
+			t.Errorf("scripts create %s with %v, model has %v", s.Table, tables[s.Table], want)
+		}
+		for _, idx := range s.ParseIndexes() {
+			if !database.Conn.Migrator().HasIndex(model, idx.Name) {
+				t.Errorf("table %s lacks index %s", s.Table, idx.Name)
+			}
+		}
+	}
+
+	var created []string
+	if err := database.Conn.Raw("SELECT name FROM sqlite_master WHERE type = 'index' AND sql IS NOT NULL ORDER BY name").
+		Scan(&created).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(created, indexes) {
+		t.Errorf("database has indexes %v, scripts create %v", created, indexes)
+	}
+}
+
+func TestMigrateDownAndUp(t *testing.T) {
+	database := newTestDB(t)
+	dump := func() []string {
+		t.Helper()
+		var sql []string
+		if err := database.Conn.Raw("SELECT sql FROM sqlite_master WHERE sql IS NOT NULL ORDER BY name").
+			Scan(&sql).Error; err != nil {
+			t.Fatal(err)
+		}
+		return sql
+	}
+	want := dump()
+
+	if applied, err := database.MigrateUp(); err != nil || applied != 0 {
+		t.Fatalf("second MigrateUp applied %d: %v", applied, err)
+	}
+	reverted, err := database.MigrateDown(100)
+	if err == nil || !strings.Contains(err.Error(), "can't be reverted") {
+		t.Errorf("reverting the initial schema: %v", err)
+	}
+	if want := len(versions(t, "sqlite")) - 1; reverted != want {
+		t.Errorf("reverted %d migrations, want %d", reverted, want)
+	}
+	pending, err := database.PendingMigrations()
+	if err != nil || pending != reverted {
+		t.Errorf("pending = %d, %v, want %d", pending, err, reverted)
+	}
+
+	if applied, err := database.MigrateUp(); err != nil || applied != reverted {
+		t.Fatalf("MigrateUp applied %d: %v", applied, err)
+	}
+	if got := dump(); !reflect.DeepEqual(got, want) {
+		t.Errorf("schema after down and up:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,52 @@
+-- The schema as first released. Databases created by earlier releases through
+-- AutoMigrate already have it, possibly in a later shape, and are left alone.
+-- There is no down script, as reverting would drop tables this never created.
+DO $$
+BEGIN
+    IF to_regclass('messages') IS NOT NULL THEN
+        RETURN;
+    END IF;
+
+    CREATE TABLE users (
+        id               uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+        created_at       timestamptz,
+        updated_at       timestamptz,
+        telegram_user_id bigint NOT NULL,
+        username         varchar(255),
+        first_name       varchar(255),
+        last_name        varchar(255)
+    );
+    CREATE UNIQUE INDEX idx_users_telegram_user_id ON users (telegram_user_id);
+
+    CREATE TABLE chats (
+        id          uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+        created_at  timestamptz,
+        updated_at  timestamptz,
+        telegram_id bigint NOT NULL,
+        title       varchar(255)
+    );
+    CREATE UNIQUE INDEX idx_chats_telegram_id ON chats (telegram_id);
+
+    CREATE TABLE chat_users (
+        id          uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+        created_at  timestamptz,
+        updated_at  timestamptz,
+        chat_id     uuid,
+        user_id     uuid,
+        dialog_name varchar(255)
+    );
+
+    CREATE TABLE messages (
+        id           uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+        created_at   timestamptz,
+        updated_at   timestamptz,
+        message_id   bigint NOT NULL,
+        user_id      uuid NOT NULL,
+        chat_id      uuid NOT NULL,
+        message_type varchar(50),
+        content      text,
+        media_url    text
+    );
+    CREATE UNIQUE INDEX idx_message_unique ON messages (message_id, user_id, chat_id);
+END
+$$;
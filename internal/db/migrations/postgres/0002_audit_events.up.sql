@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS audit_events (
+    id         uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    actor      varchar(255),
+    action     varchar(100),
+    resource   text,
+    ip         varchar(64),
+    user_agent text,
+    status     bigint,
+    created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor ON audit_events (actor);
+CREATE INDEX IF NOT EXISTS idx_audit_events_action ON audit_events (action);
+CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events (created_at);
+
+-- Append-only: updates and deletes are silently ignored.
+CREATE OR REPLACE RULE audit_events_no_update AS ON UPDATE TO audit_events DO INSTEAD NOTHING;
+CREATE OR REPLACE RULE audit_events_no_delete AS ON DELETE TO audit_events DO INSTEAD NOTHING;
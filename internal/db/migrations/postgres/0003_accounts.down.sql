@@ -0,0 +1,12 @@
+DROP INDEX IF EXISTS idx_message_account_unique;
+ALTER TABLE messages DROP COLUMN IF EXISTS account_id;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_message_unique ON messages (message_id, user_id, chat_id);
+
+DROP INDEX IF EXISTS idx_chats_account_id;
+DROP INDEX IF EXISTS idx_chats_telegram_peer;
+ALTER TABLE chats DROP COLUMN IF EXISTS account_id;
+ALTER TABLE chats DROP COLUMN IF EXISTS peer_type;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_chats_telegram_id ON chats (telegram_id);
+
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS accounts;
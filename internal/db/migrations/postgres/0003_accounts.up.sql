@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS accounts (
+    id               uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at       timestamptz,
+    updated_at       timestamptz,
+    name             varchar(255) NOT NULL,
+    telegram_user_id bigint,
+    username         varchar(255)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_accounts_name ON accounts (name);
+CREATE INDEX IF NOT EXISTS idx_accounts_telegram_user_id ON accounts (telegram_user_id);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id         uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at timestamptz,
+    updated_at timestamptz,
+    name       varchar(255) NOT NULL,
+    data       bytea NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sessions_name ON sessions (name);
+
+-- Private chats belong to the account they were archived from, so the same
+-- Telegram ID may now appear more than once.
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS peer_type varchar(20);
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS account_id uuid;
+DROP INDEX IF EXISTS idx_chats_telegram_id;
+CREATE INDEX IF NOT EXISTS idx_chats_telegram_peer ON chats (telegram_id);
+CREATE INDEX IF NOT EXISTS idx_chats_account_id ON chats (account_id);
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS account_id uuid;
+DROP INDEX IF EXISTS idx_message_unique;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_message_account_unique ON messages (message_id, user_id, chat_id, account_id);
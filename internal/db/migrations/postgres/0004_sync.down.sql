@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS sync_jobs;
+DROP TABLE IF EXISTS sync_states;
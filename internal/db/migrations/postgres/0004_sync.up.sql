@@ -0,0 +1,35 @@
+CREATE TABLE IF NOT EXISTS sync_states (
+    id                 uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at         timestamptz,
+    updated_at         timestamptz,
+    chat_id            uuid NOT NULL,
+    account_id         uuid NOT NULL,
+    last_synced_id     bigint,
+    oldest_archived_id bigint,
+    backfill_complete  boolean,
+    total_messages     bigint,
+    archived_messages  bigint,
+    last_error         text,
+    last_run_at        timestamptz
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sync_state_chat_account ON sync_states (chat_id, account_id);
+ALTER TABLE sync_states DROP CONSTRAINT IF EXISTS fk_sync_states_chat;
+ALTER TABLE sync_states
+    ADD CONSTRAINT fk_sync_states_chat FOREIGN KEY (chat_id) REFERENCES chats (id);
+
+CREATE TABLE IF NOT EXISTS sync_jobs (
+    id           uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at   timestamptz,
+    updated_at   timestamptz,
+    chat_id      uuid,
+    account_id   uuid,
+    priority     bigint NOT NULL DEFAULT 0,
+    status       varchar(20) NOT NULL,
+    error        text,
+    requested_by varchar(255),
+    started_at   timestamptz,
+    finished_at  timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_sync_jobs_chat_id ON sync_jobs (chat_id);
+CREATE INDEX IF NOT EXISTS idx_sync_jobs_account_id ON sync_jobs (account_id);
+CREATE INDEX IF NOT EXISTS idx_sync_jobs_status ON sync_jobs (status);
@@ -0,0 +1,7 @@
+-- Removed duplicates are not restored.
+ALTER TABLE messages DROP CONSTRAINT IF EXISTS fk_messages_user;
+ALTER TABLE messages DROP CONSTRAINT IF EXISTS fk_messages_chat;
+DROP INDEX IF EXISTS idx_messages_user_id;
+DROP INDEX IF EXISTS idx_messages_chat_id;
+DROP INDEX IF EXISTS idx_message_identity;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_message_account_unique ON messages (message_id, user_id, chat_id, account_id);
@@ -0,0 +1,35 @@
+-- Messages are identified by (account, chat, message ID). Keep one copy of
+-- each, preferring the one whose media was uploaded.
+DELETE FROM messages m
+USING (
+    SELECT id, ROW_NUMBER() OVER (
+        PARTITION BY account_id, chat_id, message_id
+        ORDER BY (media_url <> '') DESC, created_at
+    ) AS rn
+    FROM messages
+) d
+WHERE m.id = d.id AND d.rn > 1;
+
+-- Senders that failed to be stored left messages pointing nowhere; attribute
+-- them to a placeholder user so the foreign key can be added.
+INSERT INTO users (id, created_at, updated_at, telegram_user_id, username)
+SELECT gen_random_uuid(), now(), now(), 0, 'unknown'
+WHERE EXISTS (SELECT 1 FROM messages WHERE user_id NOT IN (SELECT id FROM users))
+ON CONFLICT (telegram_user_id) DO NOTHING;
+UPDATE messages
+SET user_id = (SELECT id FROM users WHERE telegram_user_id = 0)
+WHERE user_id NOT IN (SELECT id FROM users);
+
+DELETE FROM messages WHERE chat_id NOT IN (SELECT id FROM chats);
+
+DROP INDEX IF EXISTS idx_message_account_unique;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_message_identity ON messages (account_id, chat_id, message_id);
+CREATE INDEX IF NOT EXISTS idx_messages_chat_id ON messages (chat_id);
+CREATE INDEX IF NOT EXISTS idx_messages_user_id ON messages (user_id);
+
+ALTER TABLE messages DROP CONSTRAINT IF EXISTS fk_messages_chat;
+ALTER TABLE messages
+    ADD CONSTRAINT fk_messages_chat FOREIGN KEY (chat_id) REFERENCES chats (id) ON DELETE CASCADE;
+ALTER TABLE messages DROP CONSTRAINT IF EXISTS fk_messages_user;
+ALTER TABLE messages
+    ADD CONSTRAINT fk_messages_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE RESTRICT;
@@ -0,0 +1,4 @@
+-- Full-text index over message content. The simple configuration neither
+-- stems nor drops stop words, which suits chats in mixed languages.
+CREATE INDEX IF NOT EXISTS idx_messages_content_search
+    ON messages USING gin (to_tsvector('simple', coalesce(content, '')));
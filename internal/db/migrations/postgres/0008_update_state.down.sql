@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS update_channel_states;
+DROP TABLE IF EXISTS update_states;
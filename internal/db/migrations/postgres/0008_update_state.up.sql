@@ -0,0 +1,25 @@
+-- Where each bot left off in its update stream, so updates missed while it
+-- was disconnected or stopped are fetched as a difference on the next start.
+CREATE TABLE IF NOT EXISTS update_states (
+    id               uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at       timestamptz,
+    updated_at       timestamptz,
+    telegram_user_id bigint NOT NULL,
+    pts              bigint NOT NULL DEFAULT 0,
+    qts              bigint NOT NULL DEFAULT 0,
+    date             bigint NOT NULL DEFAULT 0,
+    seq              bigint NOT NULL DEFAULT 0
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_update_states_user ON update_states (telegram_user_id);
+
+CREATE TABLE IF NOT EXISTS update_channel_states (
+    id               uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at       timestamptz,
+    updated_at       timestamptz,
+    telegram_user_id bigint NOT NULL,
+    channel_id       bigint NOT NULL,
+    pts              bigint NOT NULL DEFAULT 0,
+    access_hash      bigint NOT NULL DEFAULT 0
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_update_channel_states_user_channel
+    ON update_channel_states (telegram_user_id, channel_id);
@@ -0,0 +1,16 @@
+-- Messages archived before accounts existed have no account, and NULLs never
+-- collide in idx_message_identity, so nothing kept them unique. Keep one copy
+-- of each, preferring the one whose media was uploaded, and index them apart.
+DELETE FROM messages m
+USING (
+    SELECT id, ROW_NUMBER() OVER (
+        PARTITION BY chat_id, message_id
+        ORDER BY (media_url <> '') DESC, created_at
+    ) AS rn
+    FROM messages
+    WHERE account_id IS NULL
+) d
+WHERE m.id = d.id AND d.rn > 1;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_legacy_message_identity ON messages (chat_id, message_id)
+    WHERE account_id IS NULL;
@@ -0,0 +1,128 @@
+-- SQLite databases start with the schema Postgres reaches at migration 0005,
+-- so later migrations share version numbers across dialects. IDs are UUIDs
+-- generated by tmd and stored as text. There is no down script, as reverting
+-- would drop the whole archive.
+CREATE TABLE users (
+    id               text PRIMARY KEY NOT NULL,
+    created_at       datetime,
+    updated_at       datetime,
+    telegram_user_id integer NOT NULL,
+    username         varchar(255),
+    first_name       varchar(255),
+    last_name        varchar(255)
+);
+CREATE UNIQUE INDEX idx_users_telegram_user_id ON users (telegram_user_id);
+
+CREATE TABLE accounts (
+    id               text PRIMARY KEY NOT NULL,
+    created_at       datetime,
+    updated_at       datetime,
+    name             varchar(255) NOT NULL,
+    telegram_user_id integer,
+    username         varchar(255)
+);
+CREATE UNIQUE INDEX idx_accounts_name ON accounts (name);
+CREATE INDEX idx_accounts_telegram_user_id ON accounts (telegram_user_id);
+
+CREATE TABLE sessions (
+    id         text PRIMARY KEY NOT NULL,
+    created_at datetime,
+    updated_at datetime,
+    name       varchar(255) NOT NULL,
+    data       blob NOT NULL
+);
+CREATE UNIQUE INDEX idx_sessions_name ON sessions (name);
+
+CREATE TABLE chats (
+    id          text PRIMARY KEY NOT NULL,
+    created_at  datetime,
+    updated_at  datetime,
+    telegram_id integer NOT NULL,
+    peer_type   varchar(20),
+    account_id  text,
+    title       varchar(255)
+);
+CREATE INDEX idx_chats_telegram_peer ON chats (telegram_id);
+CREATE INDEX idx_chats_account_id ON chats (account_id);
+
+CREATE TABLE chat_users (
+    id          text PRIMARY KEY NOT NULL,
+    created_at  datetime,
+    updated_at  datetime,
+    chat_id     text,
+    user_id     text,
+    dialog_name varchar(255)
+);
+
+CREATE TABLE messages (
+    id           text PRIMARY KEY NOT NULL,
+    created_at   datetime,
+    updated_at   datetime,
+    message_id   integer NOT NULL,
+    user_id      text NOT NULL REFERENCES users (id) ON DELETE RESTRICT,
+    chat_id      text NOT NULL REFERENCES chats (id) ON DELETE CASCADE,
+    account_id   text,
+    message_type varchar(50),
+    content      text,
+    media_url    text
+);
+CREATE UNIQUE INDEX idx_message_identity ON messages (account_id, chat_id, message_id);
+CREATE INDEX idx_messages_chat_id ON messages (chat_id);
+CREATE INDEX idx_messages_user_id ON messages (user_id);
+
+CREATE TABLE sync_states (
+    id                 text PRIMARY KEY NOT NULL,
+    created_at         datetime,
+    updated_at         datetime,
+    chat_id            text NOT NULL REFERENCES chats (id),
+    account_id         text NOT NULL,
+    last_synced_id     integer,
+    oldest_archived_id integer,
+    backfill_complete  boolean,
+    total_messages     integer,
+    archived_messages  integer,
+    last_error         text,
+    last_run_at        datetime
+);
+CREATE UNIQUE INDEX idx_sync_state_chat_account ON sync_states (chat_id, account_id);
+
+CREATE TABLE sync_jobs (
+    id           text PRIMARY KEY NOT NULL,
+    created_at   datetime,
+    updated_at   datetime,
+    chat_id      text,
+    account_id   text,
+    priority     integer NOT NULL DEFAULT 0,
+    status       varchar(20) NOT NULL,
+    error        text,
+    requested_by varchar(255),
+    started_at   datetime,
+    finished_at  datetime
+);
+CREATE INDEX idx_sync_jobs_chat_id ON sync_jobs (chat_id);
+CREATE INDEX idx_sync_jobs_account_id ON sync_jobs (account_id);
+CREATE INDEX idx_sync_jobs_status ON sync_jobs (status);
+
+CREATE TABLE audit_events (
+    id         text PRIMARY KEY NOT NULL,
+    actor      varchar(255),
+    action     varchar(100),
+    resource   text,
+    ip         varchar(64),
+    user_agent text,
+    status     integer,
+    created_at datetime
+);
+CREATE INDEX idx_audit_events_actor ON audit_events (actor);
+CREATE INDEX idx_audit_events_action ON audit_events (action);
+CREATE INDEX idx_audit_events_created_at ON audit_events (created_at);
+
+-- Append-only: updates and deletes are silently ignored.
+CREATE TRIGGER audit_events_no_update BEFORE UPDATE ON audit_events
+BEGIN
+    SELECT RAISE(IGNORE);
+END;
+CREATE TRIGGER audit_events_no_delete BEFORE DELETE ON audit_events
+BEGIN
+    SELECT RAISE(IGNORE);
+END;
@@ -0,0 +1,23 @@
+-- Full-text index over message content. It stores its own copy of the text
+-- keyed by message id rather than relying on rowids, which VACUUM may change.
+CREATE VIRTUAL TABLE messages_fts USING fts5(id UNINDEXED, content, tokenize = 'unicode61 remove_diacritics 2');
+
+INSERT INTO messages_fts (id, content)
+SELECT id, content FROM messages WHERE content <> '';
+
+CREATE TRIGGER messages_fts_insert AFTER INSERT ON messages
+WHEN new.content <> ''
+BEGIN
+    INSERT INTO messages_fts (id, content) VALUES (new.id, new.content);
+END;
+
+CREATE TRIGGER messages_fts_delete AFTER DELETE ON messages
+BEGIN
+    DELETE FROM messages_fts WHERE id = old.id;
+END;
+
+CREATE TRIGGER messages_fts_update AFTER UPDATE OF content ON messages
+BEGIN
+    DELETE FROM messages_fts WHERE id = old.id;
+    INSERT INTO messages_fts (id, content) SELECT new.id, new.content WHERE new.content <> '';
+END;
@@ -0,0 +1,4 @@
+-- Merged chats are not split again.
+DROP INDEX IF EXISTS idx_chats_owned_peer;
+DROP INDEX IF EXISTS idx_chats_shared_peer;
+CREATE INDEX IF NOT EXISTS idx_chats_telegram_peer ON chats (telegram_id);
@@ -0,0 +1,57 @@
+-- A chat is identified by its Telegram peer and, for private chats, the
+-- account owning it. Accounts syncing the same group at once could each insert
+-- it, so merge such duplicates into the oldest row before enforcing this.
+UPDATE chats SET peer_type = '' WHERE peer_type IS NULL;
+
+CREATE TEMPORARY TABLE chat_merges AS
+SELECT id AS dup_id, keep_id
+FROM (
+    SELECT id, FIRST_VALUE(id) OVER (
+        PARTITION BY telegram_id, peer_type, account_id
+        ORDER BY created_at, id
+    ) AS keep_id
+    FROM chats
+)
+WHERE id <> keep_id;
+
+-- Keep one copy of each message of the merged chats per account, preferring
+-- the one whose media was uploaded.
+DELETE FROM messages WHERE id IN (
+    SELECT id FROM (
+        SELECT msg.id, ROW_NUMBER() OVER (
+            PARTITION BY coalesce(c.keep_id, msg.chat_id), msg.account_id, msg.message_id
+            ORDER BY (msg.media_url <> '') DESC, msg.created_at
+        ) AS rn
+        FROM messages msg
+        LEFT JOIN chat_merges c ON c.dup_id = msg.chat_id
+        WHERE coalesce(c.keep_id, msg.chat_id) IN (SELECT keep_id FROM chat_merges)
+    )
+    WHERE rn > 1
+);
+UPDATE messages SET chat_id = c.keep_id FROM chat_merges c WHERE messages.chat_id = c.dup_id;
+
+-- Sync progress of a merged chat is dropped when the kept chat has its own;
+-- the next sync re-reads what is missing.
+DELETE FROM sync_states WHERE id IN (
+    SELECT id FROM (
+        SELECT st.id, ROW_NUMBER() OVER (
+            PARTITION BY coalesce(c.keep_id, st.chat_id), st.account_id
+            ORDER BY (c.keep_id IS NULL) DESC, st.last_run_at DESC NULLS LAST
+        ) AS rn
+        FROM sync_states st
+        LEFT JOIN chat_merges c ON c.dup_id = st.chat_id
+        WHERE coalesce(c.keep_id, st.chat_id) IN (SELECT keep_id FROM chat_merges)
+    )
+    WHERE rn > 1
+);
+UPDATE sync_states SET chat_id = c.keep_id FROM chat_merges c WHERE sync_states.chat_id = c.dup_id;
+UPDATE sync_jobs SET chat_id = c.keep_id FROM chat_merges c WHERE sync_jobs.chat_id = c.dup_id;
+UPDATE chat_users SET chat_id = c.keep_id FROM chat_merges c WHERE chat_users.chat_id = c.dup_id;
+DELETE FROM chats WHERE id IN (SELECT dup_id FROM chat_merges);
+DROP TABLE chat_merges;
+
+-- Shared chats have no owner, and NULLs never conflict in a unique index, so
+-- they get an index of their own.
+DROP INDEX IF EXISTS idx_chats_telegram_peer;
+CREATE UNIQUE INDEX idx_chats_shared_peer ON chats (telegram_id, peer_type) WHERE account_id IS NULL;
+CREATE UNIQUE INDEX idx_chats_owned_peer ON chats (telegram_id, peer_type, account_id) WHERE account_id IS NOT NULL;
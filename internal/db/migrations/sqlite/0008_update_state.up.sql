@@ -0,0 +1,24 @@
+-- Where each bot left off in its update stream, so updates missed while it
+-- was disconnected or stopped are fetched as a difference on the next start.
+CREATE TABLE update_states (
+    id               text PRIMARY KEY NOT NULL,
+    created_at       datetime,
+    updated_at       datetime,
+    telegram_user_id integer NOT NULL,
+    pts              integer NOT NULL DEFAULT 0,
+    qts              integer NOT NULL DEFAULT 0,
+    date             integer NOT NULL DEFAULT 0,
+    seq              integer NOT NULL DEFAULT 0
+);
+CREATE UNIQUE INDEX idx_update_states_user ON update_states (telegram_user_id);
+
+CREATE TABLE update_channel_states (
+    id               text PRIMARY KEY NOT NULL,
+    created_at       datetime,
+    updated_at       datetime,
+    telegram_user_id integer NOT NULL,
+    channel_id       integer NOT NULL,
+    pts              integer NOT NULL DEFAULT 0,
+    access_hash      integer NOT NULL DEFAULT 0
+);
+CREATE UNIQUE INDEX idx_update_channel_states_user_channel ON update_channel_states (telegram_user_id, channel_id);
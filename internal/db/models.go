@@ -7,16 +7,28 @@ import (
 )
 
 type Model struct {
-	ID        uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey"`
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
+// Account is a Telegram user archived by this deployment.
+type Account struct {
+	Model
+	Name           string `gorm:"size:255;uniqueIndex;not null"`
+	TelegramUserID int64  `gorm:"index"`
+	Username       string `gorm:"size:255"`
+}
+
+// Chat is shared by every account that sees it, except for private chats,
+// which belong to the account they were archived from. Each Telegram peer has
+// one shared chat and at most one private chat per account.
 type Chat struct {
 	Model
-	TelegramID int64     `gorm:"uniqueIndex;not null"`
-	Title      string    `gorm:"size:255"`
-	Messages   []Message `gorm:"foreignKey:ChatID;references:ID"`
+	TelegramID int64      `gorm:"uniqueIndex:idx_chats_shared_peer,priority:1,where:account_id IS NULL;uniqueIndex:idx_chats_owned_peer,priority:1,where:account_id IS NOT NULL;not null"`
+	PeerType   string     `gorm:"size:20;uniqueIndex:idx_chats_shared_peer,priority:2;uniqueIndex:idx_chats_owned_peer,priority:2"`
+	AccountID  *uuid.UUID `gorm:"type:uuid;index;uniqueIndex:idx_chats_owned_peer,priority:3"`
+	Title      string     `gorm:"size:255"`
 }
 
 func (m *Model) BeforeCreate(db *gorm.DB) (err error) {
@@ -26,13 +38,14 @@ func (m *Model) BeforeCreate(db *gorm.DB) (err error) {
 	return nil
 }
 
+// User is a message sender. Channels posting in their own name are stored as
+// users too, with the Bot API's negative -100… ID.
 type User struct {
 	Model
-	TelegramUserID int64     `gorm:"uniqueIndex;not null"`
-	Username       string    `gorm:"size:255"`
-	FirstName      string    `gorm:"size:255"`
-	LastName       string    `gorm:"size:255"`
-	Messages       []Message `gorm:"foreignKey:UserID"`
+	TelegramUserID int64  `gorm:"uniqueIndex;not null"`
+	Username       string `gorm:"size:255"`
+	FirstName      string `gorm:"size:255"`
+	LastName       string `gorm:"size:255"`
 }
 
 type ChatUser struct {
@@ -43,13 +56,97 @@ type ChatUser struct {
 	CreatedAt  time.Time `gorm:"autoCreateTime"`
 }
 
+// Message is identified by the account that archived it, its chat and its
+// Telegram message ID; the sender is not part of its identity. Messages
+// archived before accounts existed have no account and are unique per chat.
 type Message struct {
 	Model
-	MessageID   int       `gorm:"uniqueIndex:idx_message_unique,priority:1;not null"`
-	UserID      uuid.UUID `gorm:"uniqueIndex:idx_message_unique,priority:2;not null"`
-	ChatID      uuid.UUID `gorm:"uniqueIndex:idx_message_unique,priority:3;not null"`
-	MessageType string    `gorm:"size:50"`
-	Content     string    `gorm:"type:text"`
-	MediaURL    string    `gorm:"type:text"`
-	User        *User     `gorm:"foreignKey:UserID"`
+	MessageID   int        `gorm:"uniqueIndex:idx_message_identity,priority:3;uniqueIndex:idx_legacy_message_identity,priority:2,where:account_id IS NULL;not null"`
+	UserID      uuid.UUID  `gorm:"type:uuid;index;not null"`
+	ChatID      uuid.UUID  `gorm:"type:uuid;uniqueIndex:idx_message_identity,priority:2;uniqueIndex:idx_legacy_message_identity,priority:1,where:account_id IS NULL;index;not null"`
+	AccountID   *uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_message_identity,priority:1"`
+	MessageType string     `gorm:"size:50"`
+	Content     string     `gorm:"type:text;serializer:content"`
+	MediaURL    string     `gorm:"type:text"`
+	Chat        *Chat      `gorm:"foreignKey:ChatID;constraint:OnDelete:CASCADE"`
+	User        *User      `gorm:"foreignKey:UserID;constraint:OnDelete:RESTRICT"`
+}
+
+// SyncState records how far one account has archived the history of a chat.
+// Messages newer than LastSyncedID are picked up by the next sync; older
+// history is backfilled downwards from OldestArchivedID until
+// BackfillComplete is set.
+type SyncState struct {
+	Model
+	ChatID           uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_sync_state_chat_account,priority:1;not null"`
+	AccountID        uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_sync_state_chat_account,priority:2;not null"`
+	LastSyncedID     int
+	OldestArchivedID int
+	BackfillComplete bool
+	TotalMessages    int
+	ArchivedMessages int
+	LastError        string `gorm:"type:text"`
+	LastRunAt        *time.Time
+	Chat             *Chat `gorm:"foreignKey:ChatID"`
+}
+
+// SyncJob is a sync requested through the API. A job with a ChatID syncs one
+// chat; without one it runs a full pass. Jobs without an AccountID are taken
+// by whichever account claims them first.
+type SyncJob struct {
+	Model
+	ChatID      *uuid.UUID `gorm:"type:uuid;index"`
+	AccountID   *uuid.UUID `gorm:"type:uuid;index"`
+	Priority    int        `gorm:"not null;default:0"`
+	Status      string     `gorm:"size:20;index;not null"`
+	Error       string     `gorm:"type:text"`
+	RequestedBy string     `gorm:"size:255"`
+	StartedAt   *time.Time
+	FinishedAt  *time.Time
+}
+
+// UpdateState is the position of a bot in its Telegram update stream.
+type UpdateState struct {
+	Model
+	TelegramUserID int64 `gorm:"uniqueIndex:idx_update_states_user;not null"`
+	Pts            int   `gorm:"not null"`
+	Qts            int   `gorm:"not null"`
+	Date           int   `gorm:"not null"`
+	Seq            int   `gorm:"not null"`
+}
+
+// UpdateChannelState is the position of a bot in the update stream of one
+// channel, along with the access hash needed to ask for what it missed.
+type UpdateChannelState struct {
+	Model
+	TelegramUserID int64 `gorm:"uniqueIndex:idx_update_channel_states_user_channel,priority:1;not null"`
+	ChannelID      int64 `gorm:"uniqueIndex:idx_update_channel_states_user_channel,priority:2;not null"`
+	Pts            int   `gorm:"not null"`
+	AccessHash     int64 `gorm:"not null"`
+}
+
+// Session holds a persisted Telegram session, keyed by account name.
+type Session struct {
+	Model
+	Name string `gorm:"size:255;uniqueIndex;not null"`
+	Data []byte `gorm:"not null"`
+}
+
+// AuditEvent is an append-only record of a web request touching archived data.
+type AuditEvent struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Actor     string    `gorm:"size:255;index"`
+	Action    string    `gorm:"size:100;index"`
+	Resource  string    `gorm:"type:text"`
+	IP        string    `gorm:"size:64"`
+	UserAgent string    `gorm:"type:text"`
+	Status    int
+	CreatedAt time.Time `gorm:"index"`
+}
+
+func (e *AuditEvent) BeforeCreate(db *gorm.DB) (err error) {
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	return nil
 }
@@ -0,0 +1,29 @@
+package db
+
+import (
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ContentMatches returns a scope limiting a messages query to those whose
+// content contains every word of text, using the full-text index of the
+// dialect.
+func (db *DB) ContentMatches(text string) func(*gorm.DB) *gorm.DB {
+	return func(query *gorm.DB) *gorm.DB {
+		if db.Dialect == "sqlite" {
+			return query.Where("messages.id IN (SELECT id FROM messages_fts WHERE messages_fts MATCH ?)", ftsQuery(text))
+		}
+		return query.Where("to_tsvector('simple', coalesce(messages.content, '')) @@ plainto_tsquery('simple', ?)", text)
+	}
+}
+
+// ftsQuery quotes each word so FTS5 treats user input as plain terms rather
+// than query syntax.
+func ftsQuery(text string) string {
+	words := strings.Fields(text)
+	for i, word := range words {
+		words[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,106 @@
+package db
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// newChat stores a shared group chat and a sender to attach messages to.
+func newChat(t *testing.T, database *DB) (*Chat, *User) {
+	t.Helper()
+	chat := &Chat{TelegramID: 100, PeerType: "chat", Title: "Group"}
+	user := &User{TelegramUserID: 200, Username: "sender"}
+	if err := database.Conn.Create(chat).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := database.Conn.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return chat, user
+}
+
+func TestFTSQuery(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"hello", `"hello"`},
+		{"  hello   world ", `"hello" "world"`},
+		{`say "hi"`, `"say" """hi"""`},
+		{"a OR b NOT c*", `"a" "OR" "b" "NOT" "c*"`},
+		{"content:secret", `"content:secret"`},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := ftsQuery(tt.text); got != tt.want {
+			t.Errorf("ftsQuery(%q) = %s, want %s", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestContentMatches(t *testing.T) {
+	database := newTestDB(t)
+	chat, user := newChat(t, database)
+
+	contents := []string{
+		"Meeting moved to Friday",
+		"Café opens at nine",
+		"friday it is",
+		"",
+		`He said "OR" and left`,
+	}
+	messages := make([]*Message, len(contents))
+	for i, content := range contents {
+		messages[i] = &Message{MessageID: i + 1, ChatID: chat.ID, UserID: user.ID, MessageType: "text", Content: content}
+		if err := database.Conn.Create(messages[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	search := func(text string) []int {
+		t.Helper()
+		var found []Message
+		if err := database.Conn.Scopes(database.ContentMatches(text)).Find(&found).Error; err != nil {
+			t.Fatalf("search %q: %v", text, err)
+		}
+		ids := []int{}
+		for _, msg := range found {
+			ids = append(ids, msg.MessageID)
+		}
+		sort.Ints(ids)
+		return ids
+	}
+
+	tests := []struct {
+		text string
+		want []int
+	}{
+		{"friday", []int{1, 3}},
+		{"FRIDAY meeting", []int{1}},
+		{"cafe", []int{2}},
+		{"OR", []int{5}},
+		{`"said`, []int{5}},
+		{"friday NOT meeting", []int{}},
+		{"tuesday", []int{}},
+	}
+	for _, tt := range tests {
+		if got := search(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("search %q = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+
+	// The index follows edits and deletions.
+	if err := database.Conn.Model(messages[2]).Update("content", "see you tuesday").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := database.Conn.Delete(messages[0]).Error; err != nil {
+		t.Fatal(err)
+	}
+	if got := search("friday"); len(got) != 0 {
+		t.Errorf("search friday after edits = %v", got)
+	}
+	if got := search("tuesday"); !reflect.DeepEqual(got, []int{3}) {
+		t.Errorf("search tuesday after edits = %v", got)
+	}
+}
@@ -0,0 +1,54 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LoadSyncState returns the sync state of a chat for an account. A new state
+// starts out counting the messages already archived, so chats synced before
+// states existed report sensible progress.
+func (db *DB) LoadSyncState(chatID, accountID uuid.UUID) (*SyncState, error) {
+	var state SyncState
+	err := db.Conn.Where("chat_id = ? AND account_id = ?", chatID, accountID).First(&state).Error
+	if err == nil {
+		return &state, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to load sync state: %w", err)
+	}
+
+	var archived int64
+	if err := db.Conn.Model(&Message{}).
+		Where("chat_id = ? AND account_id = ?", chatID, accountID).
+		Count(&archived).Error; err != nil {
+		return nil, fmt.Errorf("failed to count archived messages: %w", err)
+	}
+	state = SyncState{
+		ChatID:           chatID,
+		AccountID:        accountID,
+		ArchivedMessages: int(archived),
+	}
+	// Another sync of the same chat may have created the state meanwhile, in
+	// which case that one is kept.
+	if err := db.Conn.Clauses(clause.OnConflict{DoNothing: true}).Create(&state).Error; err != nil {
+		return nil, fmt.Errorf("failed to create sync state: %w", err)
+	}
+	state = SyncState{}
+	if err := db.Conn.Where("chat_id = ? AND account_id = ?", chatID, accountID).First(&state).Error; err != nil {
+		return nil, fmt.Errorf("failed to load sync state: %w", err)
+	}
+	return &state, nil
+}
+
+// SaveSyncState persists the progress recorded in state.
+func (db *DB) SaveSyncState(state *SyncState) error {
+	if err := db.Conn.Omit("Chat").Save(state).Error; err != nil {
+		return fmt.Errorf("failed to save sync state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package db
+
+import (
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestLoadSyncState(t *testing.T) {
+	database := newTestDB(t)
+	chat, _ := newChat(t, database)
+	account, err := database.EnsureAccount("me")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := database.LoadSyncState(chat.ID, account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.LastSyncedID = 10
+	if err := database.SaveSyncState(state); err != nil {
+		t.Fatal(err)
+	}
+	again, err := database.LoadSyncState(chat.ID, account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != state.ID || again.LastSyncedID != 10 {
+		t.Errorf("reloaded state = %+v, want %+v", again, state)
+	}
+
+	// Another sync creates the state between the lookup and the insert.
+	other, err := database.EnsureAccount("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raced *SyncState
+	if err := database.Conn.Callback().Create().Before("gorm:create").Register("test:race", func(tx *gorm.DB) {
+		if _, ok := tx.Statement.Dest.(*SyncState); !ok || raced != nil {
+			return
+		}
+		raced = &SyncState{ChatID: chat.ID, AccountID: other.ID, LastSyncedID: 42}
+		if err := tx.Session(&gorm.Session{NewDB: true}).Create(raced).Error; err != nil {
+			t.Error(err)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	state, err = database.LoadSyncState(chat.ID, other.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raced == nil || state.ID != raced.ID || state.LastSyncedID != 42 {
+		t.Errorf("state = %+v, want the one created concurrently", state)
+	}
+}
@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"tmd/pkg/cfg"
+
+	"github.com/gotd/td/tg"
+)
+
+const doctorTimeout = 30 * time.Second
+
+type doctorCheck struct {
+	name string
+	run  func(ctx context.Context, config *cfg.Config) error
+}
+
+var doctorChecks = []doctorCheck{
+	{name: "database", run: checkDatabase},
+	{name: "storage", run: checkStorage},
+	{name: "telegram", run: checkTelegram},
+}
+
+// Doctor verifies the configuration and connectivity to every dependency and
+// prints one line per check.
+func Doctor(opts Options) error {
+	config, err := loadConfig(opts)
+	if err != nil {
+		fmt.Printf("[fail] config: %v\n", err)
+		return err
+	}
+	fmt.Printf("[ok]   config: %s\n", opts.ConfigPath)
+
+	failed := 0
+	for _, check := range doctorChecks {
+		ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+		err := check.run(ctx, config)
+		cancel()
+
+		if err != nil {
+			failed++
+			fmt.Printf("[fail] %s: %v\n", check.name, err)
+			continue
+		}
+		fmt.Printf("[ok]   %s\n", check.name)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	return nil
+}
+
+func checkDatabase(ctx context.Context, config *cfg.Config) error {
+	dbConn, err := openDB(config, false)
+	if err != nil {
+		return err
+	}
+	defer closeDB(dbConn)
+	if err := dbConn.Ping(ctx); err != nil {
+		return err
+	}
+	pending, err := dbConn.PendingMigrations()
+	if err != nil {
+		return err
+	}
+	if pending > 0 {
+		return fmt.Errorf("%d pending schema migration(s), run tmd migrate", pending)
+	}
+	return nil
+}
+
+func checkStorage(ctx context.Context, config *cfg.Config) error {
+	st, err := openStorage(config, false)
+	if err != nil {
+		return err
+	}
+	return st.Check(ctx)
+}
+
+func checkTelegram(ctx context.Context, config *cfg.Config) error {
+	var errs []error
+	limiters := newRateLimiters(config)
+	for _, account := range config.Telegram.Accounts {
+		if err := checkTelegramAccount(ctx, config, limiters, account); err != nil {
+			errs = append(errs, fmt.Errorf("account %q: %w", account.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func checkTelegramAccount(ctx context.Context, config *cfg.Config, limiters *rateLimiters, account cfg.TelegramAccount) error {
+	storage, release, err := openSessionStorage(config, account)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	client := newTelegramClient(config, limiters, storage, tg.NewUpdateDispatcher())
+	return client.Run(ctx, func(ctx context.Context) error {
+		status, err := client.Auth().Status(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get auth status: %w", err)
+		}
+		if !status.Authorized {
+			return fmt.Errorf("connected, but not logged in; run `tmd login --account %s`", account.Name)
+		}
+		return nil
+	})
+}
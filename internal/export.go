@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"tmd/internal/db"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const exportBatchSize = 500
+
+type exportedMessage struct {
+	Chat        string `json:"chat"`
+	ChatID      string `json:"chat_id"`
+	MessageID   int    `json:"message_id"`
+	Username    string `json:"username"`
+	MessageType string `json:"message_type"`
+	Content     string `json:"content"`
+	MediaURL    string `json:"media_url,omitempty"`
+	CreatedAt   string `json:"created_at"`
+}
+
+// Export writes archived messages as JSON lines to outPath, or to stdout when
+// outPath is empty. chatID limits the export to a single chat.
+func Export(opts Options, chatID, outPath string) error {
+	config, err := loadConfig(opts)
+	if err != nil {
+		return err
+	}
+
+	dbConn, err := openDB(config, false)
+	if err != nil {
+		return err
+	}
+	defer closeDB(dbConn)
+
+	query := dbConn.Conn.Model(&db.Message{}).Preload("User").Scopes(db.DistinctMessages)
+	if chatID != "" {
+		chatUUID, err := uuid.Parse(chatID)
+		if err != nil {
+			return fmt.Errorf("invalid chat id %q: %w", chatID, err)
+		}
+		query = query.Where("chat_id = ?", chatUUID)
+	}
+
+	var out io.Writer = os.Stdout
+	if outPath != "" {
+		file, err := os.Create(outPath)
+		if err != nil {
+			return fmt.Errorf("create export file: %w", err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	var chats []db.Chat
+	if err := dbConn.Conn.Find(&chats).Error; err != nil {
+		return fmt.Errorf("load chats: %w", err)
+	}
+	titles := make(map[uuid.UUID]string, len(chats))
+	for _, chat := range chats {
+		titles[chat.ID] = chat.Title
+	}
+
+	enc := json.NewEncoder(out)
+	var messages []db.Message
+	return query.Order("chat_id, message_id").FindInBatches(&messages, exportBatchSize, func(tx *gorm.DB, batch int) error {
+		for _, msg := range messages {
+			username := ""
+			if msg.User != nil {
+				username = msg.User.Username
+			}
+			if err := enc.Encode(exportedMessage{
+				Chat:        titles[msg.ChatID],
+				ChatID:      msg.ChatID.String(),
+				MessageID:   msg.MessageID,
+				Username:    username,
+				MessageType: msg.MessageType,
+				Content:     msg.Content,
+				MediaURL:    msg.MediaURL,
+				CreatedAt:   msg.CreatedAt.Format(time.RFC3339),
+			}); err != nil {
+				return fmt.Errorf("write export: %w", err)
+			}
+		}
+		return nil
+	}).Error
+}
@@ -1,55 +1,92 @@
 package fetcher
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
 
 	"tmd/internal/db"
+	"tmd/internal/metrics"
 	"tmd/pkg/filehandler"
+	"tmd/pkg/storage"
 
+	"github.com/google/uuid"
 	"github.com/gotd/td/tg"
 	"github.com/rs/zerolog/log"
 )
 
+// mediaKey identifies the media job of one message.
+type mediaKey struct {
+	chatID    uuid.UUID
+	messageID int
+}
+
 type MeJob struct {
 	MessageID      int
+	ChatID         uuid.UUID
 	TelegramUserID int64
 	Media          tg.MessageMediaClass
 	DialogName     string
+	// Date is the Unix time the message was sent.
+	Date int
 }
 
 func (f *Fetcher) workerMeJob() {
 	defer f.wg.Done()
 	for job := range f.meChan {
-		if err := f.handleMeJob(job); err != nil {
+		metrics.MediaQueueDepth.WithLabelValues(f.account.Name).Set(float64(len(f.meChan)))
+		err := f.handleMeJob(f.workerCtx, job)
+		f.queued.Delete(mediaKey{chatID: job.ChatID, messageID: job.MessageID})
+		if err != nil {
+			metrics.MediaJobs.WithLabelValues(f.account.Name, "failed").Inc()
 			log.Error().
 				Err(err).
 				Int("message_id", job.MessageID).
 				Msg("Failed to handle media job")
+			continue
 		}
+		metrics.MediaJobs.WithLabelValues(f.account.Name, "succeeded").Inc()
 	}
 }
 
-func (f *Fetcher) handleMeJob(job MeJob) error {
+func (f *Fetcher) handleMeJob(ctx context.Context, job MeJob) error {
 	mimeType, err := filehandler.GetMimeType(job.Media)
 	if err != nil {
 		return fmt.Errorf("determine MIME type: %w", err)
 	}
 
-	data, err := f.downloader.DownloadMediaToMemory(context.Background(), job.Media)
+	data, err := f.downloader.DownloadMediaToMemory(ctx, job.Media)
 	if err != nil {
 		return fmt.Errorf("download from Telegram to memory: %w", err)
 	}
+	// The downloader returns no data for media it can't fetch; storing that
+	// would leave an empty object that looks archived.
+	if len(data) == 0 {
+		return fmt.Errorf("download from Telegram returned no data")
+	}
 
 	objectName := filehandler.BuildObjectName(job.DialogName, mimeType, job.MessageID)
+	if f.objectPrefix != "" {
+		objectName = path.Join(f.objectPrefix, objectName)
+	}
 
-	mediaURL, err := f.storage.StoreBytes(context.Background(), data, mimeType, objectName)
+	mediaURL, err := f.storage.Put(ctx, objectName, bytes.NewReader(data), int64(len(data)), storage.PutOptions{
+		ContentType: mimeType,
+		Metadata:    mediaMetadata(job, data),
+	})
 	if err != nil {
-		return fmt.Errorf("upload to MinIO: %w", err)
+		return fmt.Errorf("upload to storage: %w", err)
 	}
+	metrics.MediaBytesUploaded.WithLabelValues(f.account.Name).Add(float64(len(data)))
 
 	if err := f.database.Conn.Model(&db.Message{}).
-		Where("message_id = ?", job.MessageID).
+		Where("message_id = ? AND chat_id = ? AND account_id = ?", job.MessageID, job.ChatID, f.account.ID).
 		Update("media_url", mediaURL).Error; err != nil {
 		return fmt.Errorf("update database with media URL: %w", err)
 	}
@@ -57,6 +94,24 @@ func (f *Fetcher) handleMeJob(job MeJob) error {
 	log.Info().
 		Int("message_id", job.MessageID).
 		Str("media_url", mediaURL).
-		Msg("Media uploaded to MinIO and database updated")
+		Msg("Media stored and database updated")
 	return nil
 }
+
+// mediaMetadata describes the object stored for job. The file name is
+// URL-encoded because metadata values must be ASCII.
+func mediaMetadata(job MeJob, data []byte) map[string]string {
+	sum := sha256.Sum256(data)
+	meta := map[string]string{
+		storage.MetaChatID:       job.ChatID.String(),
+		storage.MetaMessageID:    strconv.Itoa(job.MessageID),
+		storage.MetaSenderID:     strconv.FormatInt(job.TelegramUserID, 10),
+		storage.MetaSHA256:       hex.EncodeToString(sum[:]),
+		storage.MetaSize:         strconv.Itoa(len(data)),
+		storage.MetaTelegramDate: time.Unix(int64(job.Date), 0).UTC().Format(time.RFC3339),
+	}
+	if name := filehandler.GetFileName(job.Media); name != "" {
+		meta[storage.MetaFilename] = url.PathEscape(name)
+	}
+	return meta
+}
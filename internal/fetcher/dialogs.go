@@ -4,14 +4,112 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
+
 	"tmd/internal/db"
+	tgerrors "tmd/pkg/errors"
 
+	"github.com/google/uuid"
 	"github.com/gotd/td/tg"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// pendingDialog is a dialog waiting for a worker, with the date of its most
+// recent message.
+type pendingDialog struct {
+	dialog tg.DialogClass
+	users  []tg.UserClass
+	date   int
+}
+
+// FetchAllDMs archives every dialog of the account. Dialogs are spread over
+// dialogWorkers workers, most recently active first, so a single huge chat
+// doesn't hold up the rest. Chat sync jobs queued through the API run in
+// between dialogs instead of waiting for the pass to finish. The pass ends
+// with RetryMissingMedia.
 func (f *Fetcher) FetchAllDMs(ctx context.Context) error {
+	var pending []pendingDialog
+	err := f.walkDialogs(ctx, func(dialog tg.DialogClass, users []tg.UserClass, date int) (bool, error) {
+		pending = append(pending, pendingDialog{dialog: dialog, users: users, date: date})
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(pending, func(i, j int) bool { return pending[i].date > pending[j].date })
+
+	queue := make(chan pendingDialog)
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		defer close(queue)
+		for _, p := range pending {
+			select {
+			case queue <- p:
+			case <-gctx.Done():
+				return nil
+			}
+		}
+		return nil
+	})
+	for i := 0; i < f.dialogWorkers; i++ {
+		g.Go(func() error {
+			for p := range queue {
+				if err := f.processDialog(gctx, p.dialog, p.users); err != nil {
+					// A flood wait outlasting the client's retries applies to
+					// every dialog, so the pass ends here and the next one
+					// resumes from the saved sync states.
+					if tgerrors.IsReauthRequired(err) || isFloodWait(err) {
+						return err
+					}
+					log.Warn().Err(err).Msg("Failed to process dialog")
+				}
+				if _, err := f.runQueuedJobs(gctx, true); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	return f.RetryMissingMedia(ctx)
+}
+
+// SyncChat archives a single chat. Telegram needs the access hash that comes
+// with the dialog, so the chat is looked up among the account's dialogs.
+func (f *Fetcher) SyncChat(ctx context.Context, chatID uuid.UUID) error {
+	var chat db.Chat
+	if err := f.database.Conn.First(&chat, "id = ?", chatID).Error; err != nil {
+		return fmt.Errorf("failed to load chat %s: %w", chatID, err)
+	}
+	if chat.PeerType == peerTypeChannel {
+		return fmt.Errorf("chat %s is a channel; channels are only archived by bot accounts", chatID)
+	}
+
+	found := false
+	err := f.walkDialogs(ctx, func(dialog tg.DialogClass, users []tg.UserClass, _ int) (bool, error) {
+		if !dialogMatches(dialog, &chat) {
+			return true, nil
+		}
+		found = true
+		return false, f.processDialog(ctx, dialog, users)
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("chat %s is not among the dialogs of account %q", chatID, f.account.Name)
+	}
+	return nil
+}
+
+// walkDialogs pages through the account's dialogs and calls fn for each one,
+// along with the date of its top message, until fn returns false or an error.
+func (f *Fetcher) walkDialogs(ctx context.Context, fn func(tg.DialogClass, []tg.UserClass, int) (bool, error)) error {
 	tgClient := tg.NewClient(f.client)
 	offsetDate, offsetID := 0, 0
 	var offsetPeer tg.InputPeerClass = &tg.InputPeerEmpty{}
@@ -28,35 +126,98 @@ func (f *Fetcher) FetchAllDMs(ctx context.Context) error {
 			return fmt.Errorf("failed to get dialogs: %w", err)
 		}
 
+		var dialogs []tg.DialogClass
+		var users []tg.UserClass
+		var messages []tg.MessageClass
+		last := false
 		switch d := res.(type) {
 		case *tg.MessagesDialogsSlice:
-			for _, dialog := range d.Dialogs {
-				if err := f.processDialog(ctx, dialog, d.Users); err != nil {
-					log.Warn().Err(err).Msg("Failed to process dialog")
-				}
-			}
-			if len(d.Dialogs) < f.dialogsLimit {
-				return nil
-			}
-			last := d.Dialogs[len(d.Dialogs)-1]
-			offsetPeer = f.getNextOffsetPeer(last)
-			offsetID = 0
-			offsetDate = 0
-
+			dialogs, users, messages = d.Dialogs, d.Users, d.Messages
+			last = len(d.Dialogs) < f.dialogsLimit
 		case *tg.MessagesDialogs:
-			for _, dialog := range d.Dialogs {
-				if err := f.processDialog(ctx, dialog, d.Users); err != nil {
-					log.Warn().Err(err).Msg("Failed to process dialog")
-				}
-			}
-			return nil
-
+			dialogs, users, messages = d.Dialogs, d.Users, d.Messages
+			last = true
 		default:
 			log.Warn().
 				Str("type", fmt.Sprintf("%T", res)).
 				Msg("Unexpected dialog type")
 			return nil
 		}
+
+		for _, dialog := range dialogs {
+			more, err := fn(dialog, users, topMessageDate(dialog, messages))
+			if err != nil || !more {
+				return err
+			}
+		}
+		if last || len(dialogs) == 0 {
+			return nil
+		}
+		offsetPeer = f.getNextOffsetPeer(dialogs[len(dialogs)-1])
+		offsetID = 0
+		offsetDate = 0
+	}
+}
+
+// topMessageDate returns the date of the dialog's most recent message, or 0
+// when it isn't part of messages.
+func topMessageDate(dialog tg.DialogClass, messages []tg.MessageClass) int {
+	d, ok := dialog.(*tg.Dialog)
+	if !ok {
+		return 0
+	}
+	for _, msg := range messages {
+		if msg.GetID() != d.TopMessage {
+			continue
+		}
+		switch m := msg.(type) {
+		case *tg.Message:
+			if peersEqual(m.PeerID, d.Peer) {
+				return m.Date
+			}
+		case *tg.MessageService:
+			if peersEqual(m.PeerID, d.Peer) {
+				return m.Date
+			}
+		}
+	}
+	return 0
+}
+
+func peersEqual(a, b tg.PeerClass) bool {
+	switch p := a.(type) {
+	case *tg.PeerUser:
+		q, ok := b.(*tg.PeerUser)
+		return ok && p.UserID == q.UserID
+	case *tg.PeerChat:
+		q, ok := b.(*tg.PeerChat)
+		return ok && p.ChatID == q.ChatID
+	case *tg.PeerChannel:
+		q, ok := b.(*tg.PeerChannel)
+		return ok && p.ChannelID == q.ChannelID
+	default:
+		return false
+	}
+}
+
+// dialogMatches reports whether dialog is the Telegram chat behind chat.
+func dialogMatches(dialog tg.DialogClass, chat *db.Chat) bool {
+	d, ok := dialog.(*tg.Dialog)
+	if !ok {
+		return false
+	}
+	samePeer := func(peerType string, id int64) bool {
+		return chat.TelegramID == id && (chat.PeerType == peerType || chat.PeerType == "")
+	}
+	switch p := d.Peer.(type) {
+	case *tg.PeerUser:
+		return samePeer(peerTypeUser, p.UserID)
+	case *tg.PeerChat:
+		return samePeer(peerTypeChat, p.ChatID)
+	case *tg.PeerChannel:
+		return samePeer(peerTypeChannel, p.ChannelID)
+	default:
+		return false
 	}
 }
 
@@ -85,6 +246,9 @@ func (f *Fetcher) processDialog(ctx context.Context, dialog tg.DialogClass, user
 				AccessHash: 0,
 			}
 			users, err := tgClient.UsersGetUsers(ctx, []tg.InputUserClass{inputUser})
+			if isFloodWait(err) {
+				return err
+			}
 			if err != nil || len(users) == 0 {
 				log.Warn().
 					Int64("user_id", peer.UserID).
@@ -108,28 +272,9 @@ func (f *Fetcher) processDialog(ctx context.Context, dialog tg.DialogClass, user
 			UserID:     user.ID,
 			AccessHash: user.AccessHash,
 		}
-		var chat db.Chat
-		err := f.database.Conn.Where("telegram_id = ?", user.ID).First(&chat).Error
+		chat, err := f.upsertChat(user.ID, peerTypeUser, dialogName)
 		if err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				chat = db.Chat{
-					TelegramID: user.ID,
-					Title:      dialogName,
-				}
-				if err := f.database.Conn.Create(&chat).Error; err != nil {
-					return fmt.Errorf("failed to create chat record for DM: %w", err)
-				}
-				log.Info().Int64("chat_id", user.ID).Msg("Created new DM chat record")
-			} else {
-				return fmt.Errorf("failed to query DM chat record: %w", err)
-			}
-		} else {
-			if chat.Title != dialogName {
-				chat.Title = dialogName
-				if err := f.database.Conn.Save(&chat).Error; err != nil {
-					return fmt.Errorf("failed to update DM chat record: %w", err)
-				}
-			}
+			return err
 		}
 		return f.FetchAndProcessMessages(ctx, inputPeer, dialogName, chat.ID)
 
@@ -154,28 +299,9 @@ func (f *Fetcher) processDialog(ctx context.Context, dialog tg.DialogClass, user
 			dialogName = fmt.Sprintf("chat%d", chatID)
 		}
 
-		var chat db.Chat
-		err = f.database.Conn.Where("telegram_id = ?", chatID).First(&chat).Error
+		chat, err := f.upsertChat(chatID, peerTypeChat, dialogName)
 		if err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				chat = db.Chat{
-					TelegramID: chatID,
-					Title:      dialogName,
-				}
-				if err := f.database.Conn.Create(&chat).Error; err != nil {
-					return fmt.Errorf("failed to create chat record: %w", err)
-				}
-				log.Info().Int64("chat_id", chatID).Msg("Created new chat record")
-			} else {
-				return fmt.Errorf("failed to query chat record: %w", err)
-			}
-		} else {
-			if chat.Title != dialogName {
-				chat.Title = dialogName
-				if err := f.database.Conn.Save(&chat).Error; err != nil {
-					return fmt.Errorf("failed to update chat record: %w", err)
-				}
-			}
+			return err
 		}
 
 		inputPeer := &tg.InputPeerChat{
@@ -191,6 +317,97 @@ func (f *Fetcher) processDialog(ctx context.Context, dialog tg.DialogClass, user
 	}
 }
 
+const (
+	peerTypeUser = "user"
+	peerTypeChat = "chat"
+)
+
+// upsertChat finds or creates the chat record for a dialog. Private chats are
+// owned by the current account; group chats are shared between accounts.
+// Rows archived before accounts existed have no owner and no peer type and
+// are claimed by the first account that sees them. Accounts syncing the same
+// group at once race to create it, so the insert yields to the unique index
+// and the winner's row is read back.
+func (f *Fetcher) upsertChat(telegramID int64, peerType, title string) (*db.Chat, error) {
+	var owner *uuid.UUID
+	if peerType == peerTypeUser {
+		owner = &f.account.ID
+	}
+
+	chat, err := f.lookupChat(telegramID, peerType)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		chat = &db.Chat{
+			TelegramID: telegramID,
+			PeerType:   peerType,
+			AccountID:  owner,
+			Title:      title,
+		}
+		res := f.database.Conn.Clauses(chatConflict(owner)).Create(chat)
+		if res.Error != nil {
+			return nil, fmt.Errorf("failed to create chat record: %w", res.Error)
+		}
+		if res.RowsAffected > 0 {
+			log.Info().
+				Int64("chat_id", telegramID).
+				Str("peer_type", peerType).
+				Msg("Created new chat record")
+			return chat, nil
+		}
+		chat, err = f.lookupChat(telegramID, peerType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query chat record: %w", err)
+	}
+
+	if chat.Title != title || chat.PeerType != peerType || (owner != nil && chat.AccountID == nil) {
+		chat.Title = title
+		chat.PeerType = peerType
+		chat.AccountID = owner
+		if err := f.database.Conn.Save(chat).Error; err != nil {
+			return nil, fmt.Errorf("failed to update chat record: %w", err)
+		}
+	}
+	return chat, nil
+}
+
+// lookupChat returns the chat record for a dialog, preferring one of the right
+// peer type and, for private chats, owned by the current account over a row
+// archived before either existed.
+func (f *Fetcher) lookupChat(telegramID int64, peerType string) (*db.Chat, error) {
+	query := f.database.Conn.
+		Where("telegram_id = ?", telegramID).
+		Where("peer_type = ? OR peer_type = ''", peerType)
+	if peerType == peerTypeUser {
+		query = query.
+			Where("account_id = ? OR account_id IS NULL", f.account.ID).
+			Order("account_id IS NULL")
+	} else {
+		query = query.Where("account_id IS NULL")
+	}
+
+	var chat db.Chat
+	if err := query.Order("peer_type = ''").First(&chat).Error; err != nil {
+		return nil, err
+	}
+	return &chat, nil
+}
+
+// chatConflict targets the partial unique index a new chat falls under:
+// idx_chats_owned_peer for private chats and idx_chats_shared_peer otherwise.
+func chatConflict(owner *uuid.UUID) clause.OnConflict {
+	conflict := clause.OnConflict{
+		Columns:   []clause.Column{{Name: "telegram_id"}, {Name: "peer_type"}},
+		DoNothing: true,
+	}
+	where := "account_id IS NULL"
+	if owner != nil {
+		conflict.Columns = append(conflict.Columns, clause.Column{Name: "account_id"})
+		where = "account_id IS NOT NULL"
+	}
+	conflict.TargetWhere = clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: where}}}
+	return conflict
+}
+
 func findChat(res *tg.MessagesChats, chatID int64) (*tg.Chat, bool) {
 	for _, c := range res.Chats {
 		if chatObj, ok := c.(*tg.Chat); ok {
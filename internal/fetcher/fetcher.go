@@ -1,45 +1,76 @@
 package fetcher
 
 import (
+	"context"
 	"sync"
-	"tmd/pkg/minio"
+	"time"
 
 	"tmd/internal/db"
+	"tmd/internal/metrics"
+	tgerrors "tmd/pkg/errors"
 	"tmd/pkg/filehandler"
+	"tmd/pkg/storage"
 
 	"github.com/gotd/td/telegram"
+	"github.com/rs/zerolog/log"
 )
 
+// Options tune how a Fetcher pages through Telegram and how much it does in
+// parallel.
+type Options struct {
+	// ObjectPrefix is prepended to the object names of uploaded media.
+	ObjectPrefix  string
+	DialogsLimit  int
+	MessagesLimit int
+	DialogWorkers int
+	MediaWorkers  int
+}
+
 type Fetcher struct {
 	client        *telegram.Client
 	downloader    *filehandler.Downloader
 	database      *db.DB
-	storage       *minio.Storage
+	storage       storage.Storage
+	account       *db.Account
+	objectPrefix  string
 	dialogsLimit  int
 	messagesLimit int
+	dialogWorkers int
 	myUserID      int64
 	meChan        chan MeJob
+	// queued holds the mediaKey of every media job not yet finished, so the
+	// retry pass doesn't queue a download that is still waiting its turn.
+	queued        sync.Map
 	wg            sync.WaitGroup
+	closeOnce     sync.Once
+	workerCtx     context.Context
+	cancelWorkers context.CancelFunc
 }
 
 func NewFetcher(client *telegram.Client,
 	downloader *filehandler.Downloader,
 	database *db.DB,
-	storage *minio.Storage,
-	dialogsLimit, messagesLimit int,
+	storage storage.Storage,
+	account *db.Account,
+	opts Options,
 ) *Fetcher {
+	workerCtx, cancelWorkers := context.WithCancel(context.Background())
 	f := &Fetcher{
 		client:        client,
 		downloader:    downloader,
 		database:      database,
 		storage:       storage,
-		dialogsLimit:  dialogsLimit,
-		messagesLimit: messagesLimit,
+		account:       account,
+		objectPrefix:  opts.ObjectPrefix,
+		dialogsLimit:  opts.DialogsLimit,
+		messagesLimit: opts.MessagesLimit,
+		dialogWorkers: max(opts.DialogWorkers, 1),
 		meChan:        make(chan MeJob, 8),
+		workerCtx:     workerCtx,
+		cancelWorkers: cancelWorkers,
 	}
 
-	workerCount := 5
-	for i := 0; i < workerCount; i++ {
+	for i := 0; i < max(opts.MediaWorkers, 1); i++ {
 		f.wg.Add(1)
 		go f.workerMeJob()
 	}
@@ -51,7 +82,87 @@ func (f *Fetcher) SetMyUserID(id int64) {
 	f.myUserID = id
 }
 
-func (f *Fetcher) CloseWorkers() {
-	close(f.meChan)
-	f.wg.Wait()
+// SyncLoop fetches all dialogs every interval and runs sync jobs queued
+// through the API in between, until ctx is cancelled. It only returns an
+// error when the session has to be re-authenticated; other failures are
+// logged and retried on the next pass.
+func (f *Fetcher) SyncLoop(ctx context.Context, interval time.Duration) error {
+	if err := f.database.RequeueSyncJobs(f.account.ID); err != nil {
+		log.Error().Err(err).Msg("Failed to requeue interrupted sync jobs")
+	}
+
+	nextPass := time.Now()
+	for {
+		if !time.Now().Before(nextPass) {
+			delay := interval
+			if err := f.fullSync(ctx); err != nil && ctx.Err() == nil {
+				if tgerrors.IsReauthRequired(err) {
+					return err
+				}
+				if wait, ok := tgerrors.AsFloodWait(err); ok {
+					delay = max(delay, wait)
+				}
+				log.Error().Err(tgerrors.HandleTGError(err)).Dur("next_pass_in", delay).Msg("Failed to fetch DMs")
+			}
+			nextPass = time.Now().Add(delay)
+		}
+
+		ranFullSync, err := f.runQueuedJobs(ctx, false)
+		if err != nil {
+			return err
+		}
+		if ranFullSync {
+			nextPass = time.Now().Add(interval)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(jobPollInterval):
+		}
+	}
+}
+
+// fullSync runs FetchAllDMs and records how long it took.
+func (f *Fetcher) fullSync(ctx context.Context) error {
+	start := time.Now()
+	err := f.FetchAllDMs(ctx)
+	metrics.SyncDuration.WithLabelValues(f.account.Name).Observe(time.Since(start).Seconds())
+	if err == nil {
+		metrics.LastSyncSuccess.WithLabelValues(f.account.Name).SetToCurrentTime()
+	}
+	return err
+}
+
+func isFloodWait(err error) bool {
+	_, ok := tgerrors.AsFloodWait(err)
+	return ok
+}
+
+// CloseWorkers stops accepting media jobs and lets the workers drain the
+// queue. Jobs still running after timeout are cancelled; their messages keep
+// an empty media URL and are retried by RetryMissingMedia on the next run.
+// Calls after the first are no-ops.
+func (f *Fetcher) CloseWorkers(timeout time.Duration) {
+	f.closeOnce.Do(func() {
+		close(f.meChan)
+
+		done := make(chan struct{})
+		go func() {
+			f.wg.Wait()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+			log.Info().Msg("Media workers drained")
+		case <-time.After(timeout):
+			log.Warn().
+				Dur("timeout", timeout).
+				Msg("Media workers did not drain in time; cancelling in-flight jobs")
+			f.cancelWorkers()
+			<-done
+		}
+		f.cancelWorkers()
+	})
 }
@@ -0,0 +1,57 @@
+package fetcher
+
+import (
+	"context"
+	"time"
+
+	tgerrors "tmd/pkg/errors"
+
+	"github.com/rs/zerolog/log"
+)
+
+// jobPollInterval is how often SyncLoop checks for sync jobs queued through
+// the API while it is idle.
+const jobPollInterval = 5 * time.Second
+
+// runQueuedJobs runs queued sync jobs until none are left for the account,
+// only chat jobs when chatOnly is set. It reports whether a full pass ran and
+// only returns errors that require logging in again.
+func (f *Fetcher) runQueuedJobs(ctx context.Context, chatOnly bool) (bool, error) {
+	fullSync := false
+	for ctx.Err() == nil {
+		job, err := f.database.ClaimSyncJob(f.account.ID, chatOnly)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to claim sync job")
+			return fullSync, nil
+		}
+		if job == nil {
+			return fullSync, nil
+		}
+
+		log.Info().
+			Str("job_id", job.ID.String()).
+			Str("requested_by", job.RequestedBy).
+			Msg("Running sync job")
+
+		var jobErr error
+		if job.ChatID == nil {
+			fullSync = true
+			jobErr = f.fullSync(ctx)
+		} else {
+			jobErr = f.SyncChat(ctx, *job.ChatID)
+		}
+		if ctx.Err() != nil {
+			// Left running; RequeueSyncJobs puts it back on the next start.
+			return fullSync, nil
+		}
+
+		jobErr = tgerrors.HandleTGError(jobErr)
+		if err := f.database.FinishSyncJob(job, jobErr); err != nil {
+			log.Error().Err(err).Str("job_id", job.ID.String()).Msg("Failed to record sync job result")
+		}
+		if tgerrors.IsReauthRequired(jobErr) {
+			return fullSync, jobErr
+		}
+	}
+	return fullSync, nil
+}
@@ -2,78 +2,175 @@ package fetcher
 
 import (
 	"context"
-	"errors"
 	"fmt"
+	"slices"
+	"time"
+
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"tmd/internal/db"
+	"tmd/internal/metrics"
 
 	"github.com/gotd/td/tg"
 	"github.com/rs/zerolog/log"
 )
 
-func (f *Fetcher) FetchAndProcessMessages(ctx context.Context, peer tg.InputPeerClass, dialogName string, chatUUID uuid.UUID) error {
-	tgClient := tg.NewClient(f.client)
-	offsetID := 0
-
-	for {
-		history, err := tgClient.MessagesGetHistory(ctx, &tg.MessagesGetHistoryRequest{
-			Peer:     peer,
-			OffsetID: offsetID,
-			Limit:    f.messagesLimit,
-		})
+// FetchAndProcessMessages archives the history of one chat. Messages newer
+// than the last sync are fetched first, then the backfill of older history
+// continues from the oldest archived message. Backfill progress is saved after
+// every page, so an interrupted sync resumes where it stopped.
+func (f *Fetcher) FetchAndProcessMessages(ctx context.Context, peer tg.InputPeerClass, dialogName string, chatUUID uuid.UUID) (err error) {
+	state, err := f.database.LoadSyncState(chatUUID, f.account.ID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	state.LastRunAt = &now
+	defer func() {
+		state.LastError = ""
 		if err != nil {
-			return fmt.Errorf("failed to fetch message history: %w", err)
+			state.LastError = err.Error()
+		}
+		if saveErr := f.database.SaveSyncState(state); saveErr != nil {
+			log.Error().Err(saveErr).Str("chat_id", chatUUID.String()).Msg("Failed to save sync state")
 		}
+	}()
 
-		switch msgs := history.(type) {
-		case *tg.MessagesChannelMessages:
-			if len(msgs.Messages) == 0 {
-				return nil
-			}
-			if err := f.processMessagesBatch(ctx, msgs.Messages, &offsetID, dialogName, chatUUID); err != nil {
-				return err
-			}
-			if len(msgs.Messages) < f.messagesLimit {
-				return nil
-			}
+	if err := f.catchUp(ctx, peer, dialogName, state); err != nil {
+		return err
+	}
+	return f.backfill(ctx, peer, dialogName, state)
+}
 
-		case *tg.MessagesMessagesSlice:
-			if len(msgs.Messages) == 0 {
-				return nil
-			}
-			if err := f.processMessagesBatch(ctx, msgs.Messages, &offsetID, dialogName, chatUUID); err != nil {
-				return err
-			}
-			if len(msgs.Messages) < f.messagesLimit {
-				return nil
-			}
+// catchUp archives messages newer than state.LastSyncedID. On the first sync
+// of a chat it only archives the newest page and leaves the rest to backfill.
+func (f *Fetcher) catchUp(ctx context.Context, peer tg.InputPeerClass, dialogName string, state *db.SyncState) error {
+	offsetID, newest := 0, state.LastSyncedID
+	for {
+		messages, count, err := f.getHistoryPage(ctx, peer, offsetID, state.LastSyncedID)
+		if err != nil {
+			return err
+		}
+		created, err := f.processMessagesBatch(ctx, messages, dialogName, state.ChatID)
+		state.ArchivedMessages += created
+		if err != nil {
+			return err
+		}
 
-		case *tg.MessagesMessages:
-			if len(msgs.Messages) == 0 {
-				return nil
-			}
-			if err := f.processMessagesBatch(ctx, msgs.Messages, &offsetID, dialogName, chatUUID); err != nil {
-				return err
-			}
+		oldest, top := messageIDRange(messages)
+		newest = max(newest, top)
+		if state.LastSyncedID == 0 {
+			state.LastSyncedID = newest
+			state.OldestArchivedID = oldest
+			state.TotalMessages = count
+			state.BackfillComplete = len(messages) < f.messagesLimit
 			return nil
+		}
 
-		default:
-			log.Warn().
-				Str("type", fmt.Sprintf("%T", history)).
-				Msg("Unexpected message history type")
+		state.TotalMessages += created
+		if len(messages) < f.messagesLimit || oldest == 0 {
+			state.LastSyncedID = newest
 			return nil
 		}
+		offsetID = oldest
+	}
+}
+
+// backfill archives history older than state.OldestArchivedID, one page at a
+// time, until the beginning of the chat is reached.
+func (f *Fetcher) backfill(ctx context.Context, peer tg.InputPeerClass, dialogName string, state *db.SyncState) error {
+	for !state.BackfillComplete {
+		messages, count, err := f.getHistoryPage(ctx, peer, state.OldestArchivedID, 0)
+		if err != nil {
+			return err
+		}
+		created, err := f.processMessagesBatch(ctx, messages, dialogName, state.ChatID)
+		state.ArchivedMessages += created
+		if err != nil {
+			return err
+		}
+
+		oldest, _ := messageIDRange(messages)
+		if oldest != 0 {
+			state.OldestArchivedID = oldest
+		}
+		state.TotalMessages = max(state.TotalMessages, count)
+		state.BackfillComplete = len(messages) < f.messagesLimit || oldest == 0
+		if err := f.database.SaveSyncState(state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getHistoryPage returns up to messagesLimit messages with IDs between minID
+// and offsetID (exclusive, 0 meaning unbounded), newest first, along with
+// Telegram's count of messages in the chat.
+func (f *Fetcher) getHistoryPage(ctx context.Context, peer tg.InputPeerClass, offsetID, minID int) ([]tg.MessageClass, int, error) {
+	tgClient := tg.NewClient(f.client)
+
+	history, err := tgClient.MessagesGetHistory(ctx, &tg.MessagesGetHistoryRequest{
+		Peer:     peer,
+		OffsetID: offsetID,
+		MinID:    minID,
+		Limit:    f.messagesLimit,
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch message history: %w", err)
+	}
+	messages, count := unpackMessages(history)
+	return messages, count, nil
+}
+
+// unpackMessages returns the messages in res along with Telegram's count of
+// messages in the chat.
+func unpackMessages(res tg.MessagesMessagesClass) ([]tg.MessageClass, int) {
+	switch msgs := res.(type) {
+	case *tg.MessagesChannelMessages:
+		return msgs.Messages, msgs.Count
+	case *tg.MessagesMessagesSlice:
+		return msgs.Messages, msgs.Count
+	case *tg.MessagesMessages:
+		return msgs.Messages, len(msgs.Messages)
+	default:
+		log.Warn().
+			Str("type", fmt.Sprintf("%T", res)).
+			Msg("Unexpected messages type")
+		return nil, 0
+	}
+}
+
+// messageIDRange returns the lowest and highest message ID in messages, or
+// zeros when there are none.
+func messageIDRange(messages []tg.MessageClass) (int, int) {
+	oldest, newest := 0, 0
+	for _, msg := range messages {
+		id := msg.GetID()
+		if oldest == 0 || id < oldest {
+			oldest = id
+		}
+		newest = max(newest, id)
 	}
+	return oldest, newest
 }
 
+// processMessagesBatch stores a page of messages and queues their media. Users
+// and messages are upserted in one transaction, so a page costs a handful of
+// round trips regardless of its size. It returns how many messages were new.
 func (f *Fetcher) processMessagesBatch(
 	ctx context.Context,
 	messages []tg.MessageClass,
-	offsetID *int,
 	dialogName string,
 	chatUUID uuid.UUID,
-) error {
+) (int, error) {
+	type pageMessage struct {
+		msg    *tg.Message
+		sender int64
+	}
+	page := make([]pageMessage, 0, len(messages))
+	senders := make(map[int64]bool)
+	hasMedia := false
 	for _, msg := range messages {
 		m, ok := msg.(*tg.Message)
 		if !ok {
@@ -83,83 +180,187 @@ func (f *Fetcher) processMessagesBatch(
 			continue
 		}
 
-		log.Info().
+		log.Debug().
 			Int("message_id", m.ID).
 			Str("content", m.Message).
 			Msg("Processing message")
 
-		senderUserID, valid := peerUserID(m.FromID)
+		senderUserID, valid := f.messageSender(m)
 		if !valid || senderUserID == 0 {
 			log.Warn().Int("message_id", m.ID).Msg("Skipping message with invalid sender")
 			continue
 		}
+		page = append(page, pageMessage{msg: m, sender: senderUserID})
+		senders[senderUserID] = true
+		hasMedia = hasMedia || m.Media != nil
+	}
+	if len(page) == 0 {
+		return 0, nil
+	}
 
-		var userRecord db.User
-		if senderUserID != 0 {
-			err := f.database.Conn.Where("telegram_user_id = ?", senderUserID).
-				First(&userRecord).
-				Error
-			if err != nil {
-				if errors.Is(err, gorm.ErrRecordNotFound) {
-					userRecord = db.User{
-						TelegramUserID: senderUserID,
-						Username:       fmt.Sprintf("user%d", senderUserID),
-					}
-					if errCreate := f.database.Conn.Create(&userRecord).Error; errCreate != nil {
-						log.Error().Err(errCreate).Msg("Failed to create user record")
-					}
-				} else {
-					log.Error().Err(err).Msg("Failed to query user record")
-				}
-			}
+	var created int64
+	missingMedia := make(map[int]bool)
+	err := f.database.Conn.Transaction(func(tx *gorm.DB) error {
+		userIDs, err := upsertUsers(tx, senders)
+		if err != nil {
+			return err
 		}
 
-		msgType := "text"
-		if m.Media != nil {
-			switch m.Media.(type) {
-			case *tg.MessageMediaPhoto:
-				msgType = "photo"
-			case *tg.MessageMediaDocument:
-				msgType = "document"
+		records := make([]db.Message, len(page))
+		for i, p := range page {
+			records[i] = db.Message{
+				MessageID:   p.msg.ID,
+				ChatID:      chatUUID,
+				AccountID:   &f.account.ID,
+				UserID:      userIDs[p.sender],
+				Content:     p.msg.Message,
+				MessageType: messageType(p.msg),
 			}
 		}
-
-		messageRecord := db.Message{
-			MessageID:   m.ID,
-			ChatID:      chatUUID,
-			UserID:      userRecord.ID,
-			Content:     m.Message,
-			MessageType: msgType,
+		res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&records)
+		if res.Error != nil {
+			return fmt.Errorf("failed to insert messages: %w", res.Error)
 		}
+		created = res.RowsAffected
 
-		if err := f.database.Conn.
-			Where("message_id = ? AND chat_id = ?", m.ID, chatUUID).
-			FirstOrCreate(&messageRecord).Error; err != nil {
-			log.Error().Err(err).Msg("Failed to create/find message record")
+		if !hasMedia {
+			return nil
+		}
+		// Includes messages archived earlier whose upload never finished.
+		ids := make([]int, len(page))
+		for i, p := range page {
+			ids[i] = p.msg.ID
+		}
+		var missing []int
+		if err := tx.Model(&db.Message{}).
+			Where("chat_id = ? AND account_id = ? AND media_url = ''", chatUUID, f.account.ID).
+			Where("message_id IN ?", ids).
+			Pluck("message_id", &missing).Error; err != nil {
+			return fmt.Errorf("failed to look up missing media: %w", err)
 		}
+		for _, id := range missing {
+			missingMedia[id] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to store messages: %w", err)
+	}
 
-		if m.Media != nil {
-			job := MeJob{
-				MessageID:      m.ID,
-				TelegramUserID: senderUserID,
-				Media:          m.Media,
-				DialogName:     dialogName,
-			}
-			f.meChan <- job
+	if created > 0 {
+		metrics.MessagesArchived.WithLabelValues(f.account.Name, chatUUID.String()).Add(float64(created))
+		metrics.LastMessageArchived.WithLabelValues(f.account.Name).SetToCurrentTime()
+	}
+
+	for _, p := range page {
+		if p.msg.Media == nil || !missingMedia[p.msg.ID] {
+			continue
 		}
+		key := mediaKey{chatID: chatUUID, messageID: p.msg.ID}
+		if _, queued := f.queued.LoadOrStore(key, true); queued {
+			continue
+		}
+		job := MeJob{
+			MessageID:      p.msg.ID,
+			ChatID:         chatUUID,
+			TelegramUserID: p.sender,
+			Media:          p.msg.Media,
+			DialogName:     dialogName,
+			Date:           p.msg.Date,
+		}
+		select {
+		case f.meChan <- job:
+			metrics.MediaJobs.WithLabelValues(f.account.Name, "queued").Inc()
+			metrics.MediaQueueDepth.WithLabelValues(f.account.Name).Set(float64(len(f.meChan)))
+		case <-ctx.Done():
+			f.queued.Delete(key)
+			return int(created), ctx.Err()
+		}
+	}
+	return int(created), nil
+}
+
+// upsertUsers creates placeholder records for Telegram users seen for the
+// first time and returns the record IDs of all of them. Users are inserted in
+// ID order so concurrent dialog workers lock them in the same order.
+func upsertUsers(tx *gorm.DB, telegramUserIDs map[int64]bool) (map[int64]uuid.UUID, error) {
+	ids := make([]int64, 0, len(telegramUserIDs))
+	for id := range telegramUserIDs {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
 
-		if *offsetID == 0 || m.ID < *offsetID {
-			*offsetID = m.ID
+	users := make([]db.User, len(ids))
+	for i, id := range ids {
+		username := fmt.Sprintf("user%d", id)
+		if id < 0 {
+			username = fmt.Sprintf("channel%d", channelSenderBase-id)
+		}
+		users[i] = db.User{
+			TelegramUserID: id,
+			Username:       username,
 		}
 	}
-	return nil
+	if err := tx.
+		Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "telegram_user_id"}}, DoNothing: true}).
+		Create(&users).Error; err != nil {
+		return nil, fmt.Errorf("failed to insert users: %w", err)
+	}
+
+	var existing []db.User
+	if err := tx.Select("id", "telegram_user_id").Where("telegram_user_id IN ?", ids).Find(&existing).Error; err != nil {
+		return nil, fmt.Errorf("failed to load users: %w", err)
+	}
+	byTelegramID := make(map[int64]uuid.UUID, len(existing))
+	for _, u := range existing {
+		byTelegramID[u.TelegramUserID] = u.ID
+	}
+	return byTelegramID, nil
+}
+
+func messageType(m *tg.Message) string {
+	switch m.Media.(type) {
+	case *tg.MessageMediaPhoto:
+		return "photo"
+	case *tg.MessageMediaDocument:
+		return "document"
+	default:
+		return "text"
+	}
 }
 
-func peerUserID(peer tg.PeerClass) (int64, bool) {
-	switch p := peer.(type) {
+// messageSender returns the Telegram user who sent m. Messages in private
+// chats may omit FromID; the sender is then the peer for incoming messages
+// and the account itself for outgoing ones. Posts made on behalf of a channel,
+// and channel posts without a sender, are attributed to the channel.
+func (f *Fetcher) messageSender(m *tg.Message) (int64, bool) {
+	switch from := m.FromID.(type) {
 	case *tg.PeerUser:
-		return p.UserID, true
+		return from.UserID, true
+	case *tg.PeerChannel:
+		return channelSenderID(from.ChannelID), true
+	case nil:
 	default:
 		return 0, false
 	}
+
+	switch peer := m.PeerID.(type) {
+	case *tg.PeerUser:
+		if m.Out {
+			return f.myUserID, f.myUserID != 0
+		}
+		return peer.UserID, true
+	case *tg.PeerChannel:
+		return channelSenderID(peer.ChannelID), true
+	default:
+		return 0, false
+	}
+}
+
+// channelSenderBase offsets channel IDs the way the Bot API does (-100…), so
+// channels can be stored as senders without colliding with user IDs.
+const channelSenderBase = -1_000_000_000_000
+
+func channelSenderID(channelID int64) int64 {
+	return channelSenderBase - channelID
 }
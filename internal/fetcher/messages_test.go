@@ -0,0 +1,148 @@
+package fetcher
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/gotd/td/tg"
+	"tmd/internal/db"
+	"tmd/pkg/cfg"
+)
+
+// newTestFetcher returns a fetcher on a migrated SQLite database, without a
+// Telegram client or media workers; queued media jobs stay in meChan.
+func newTestFetcher(t *testing.T, database *db.DB, name string) *Fetcher {
+	t.Helper()
+	account, err := database.EnsureAccount(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Fetcher{
+		database: database,
+		account:  account,
+		myUserID: 1,
+		meChan:   make(chan MeJob, 16),
+	}
+}
+
+func newTestDB(t *testing.T) *db.DB {
+	t.Helper()
+	var c cfg.Config
+	c.Database.Dialect = "sqlite"
+	c.Database.Path = filepath.Join(t.TempDir(), "tmd.db")
+	c.Database.LogLevel = "silent"
+	database, err := db.NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = database.Shutdown() })
+	if _, err := database.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	return database
+}
+
+func TestProcessMessagesBatch(t *testing.T) {
+	ctx := context.Background()
+	database := newTestDB(t)
+	f := newTestFetcher(t, database, "me")
+	chat := &db.Chat{TelegramID: 100, PeerType: "channel", Title: "News"}
+	if err := database.Conn.Create(chat).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	page := []tg.MessageClass{
+		&tg.Message{ID: 1, FromID: &tg.PeerUser{UserID: 10}, PeerID: &tg.PeerChannel{ChannelID: 100}, Message: "hello"},
+		&tg.Message{ID: 2, FromID: &tg.PeerUser{UserID: 11}, PeerID: &tg.PeerChannel{ChannelID: 100}, Media: &tg.MessageMediaPhoto{}},
+		// A channel post without a sender is attributed to the channel.
+		&tg.Message{ID: 3, PeerID: &tg.PeerChannel{ChannelID: 100}, Message: "announcement"},
+		&tg.Message{ID: 4, FromID: &tg.PeerChat{ChatID: 5}, PeerID: &tg.PeerChannel{ChannelID: 100}},
+		&tg.MessageService{ID: 5},
+	}
+	created, err := f.processMessagesBatch(ctx, page, chat.Title, chat.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created != 3 {
+		t.Errorf("created %d messages, want 3", created)
+	}
+
+	var stored []db.Message
+	if err := database.Conn.Preload("User").Order("message_id").Find(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		id          int
+		sender      int64
+		messageType string
+		content     string
+	}{
+		{1, 10, "text", "hello"},
+		{2, 11, "photo", ""},
+		{3, channelSenderID(100), "text", "announcement"},
+	}
+	if len(stored) != len(want) {
+		t.Fatalf("stored %d messages, want %d", len(stored), len(want))
+	}
+	for i, w := range want {
+		m := stored[i]
+		if m.MessageID != w.id || m.User.TelegramUserID != w.sender || m.MessageType != w.messageType ||
+			m.Content != w.content || m.AccountID == nil || *m.AccountID != f.account.ID {
+			t.Errorf("message %d = %+v, sender %d", w.id, m, m.User.TelegramUserID)
+		}
+	}
+	if len(f.meChan) != 1 {
+		t.Fatalf("queued %d media jobs, want 1", len(f.meChan))
+	}
+
+	// Stored messages are left alone, and media still waiting in the queue
+	// isn't queued twice.
+	page[0].(*tg.Message).Message = "edited"
+	if created, err := f.processMessagesBatch(ctx, page, chat.Title, chat.ID); err != nil || created != 0 {
+		t.Fatalf("second pass created %d: %v", created, err)
+	}
+	if len(f.meChan) != 1 {
+		t.Errorf("queued %d media jobs after the second pass, want 1", len(f.meChan))
+	}
+	var first db.Message
+	if err := database.Conn.First(&first, "message_id = 1").Error; err != nil || first.Content != "hello" {
+		t.Errorf("message 1 after the second pass = %+v, %v", first, err)
+	}
+
+	// Media whose download finished without an upload is queued again.
+	job := <-f.meChan
+	f.queued.Delete(mediaKey{chatID: job.ChatID, messageID: job.MessageID})
+	if job.MessageID != 2 || job.TelegramUserID != 11 || job.DialogName != chat.Title {
+		t.Errorf("media job = %+v", job)
+	}
+	if _, err := f.processMessagesBatch(ctx, page, chat.Title, chat.ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.meChan) != 1 {
+		t.Fatalf("queued %d media jobs for missing media, want 1", len(f.meChan))
+	}
+	job = <-f.meChan
+	f.queued.Delete(mediaKey{chatID: job.ChatID, messageID: job.MessageID})
+
+	// Uploaded media isn't.
+	if err := database.Conn.Model(&db.Message{}).Where("message_id = 2").Update("media_url", "file://chat/2.jpg").Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.processMessagesBatch(ctx, page, chat.Title, chat.ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.meChan) != 0 {
+		t.Errorf("queued %d media jobs for uploaded media", len(f.meChan))
+	}
+
+	// Every account keeps its own copy of a shared chat.
+	other := newTestFetcher(t, database, "other")
+	if created, err := other.processMessagesBatch(ctx, page, chat.Title, chat.ID); err != nil || created != 3 {
+		t.Errorf("other account created %d: %v", created, err)
+	}
+	var users int64
+	if err := database.Conn.Model(&db.User{}).Count(&users).Error; err != nil || users != 3 {
+		t.Errorf("stored %d users, want 3: %v", users, err)
+	}
+}
@@ -0,0 +1,129 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"tmd/internal/db"
+
+	"github.com/google/uuid"
+	"github.com/gotd/td/tg"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// retryBatchSize is the most messages Telegram returns by ID in one request.
+const retryBatchSize = 100
+
+// RetryMissingMedia queues the media of the account's messages that were
+// archived without it again. Their downloads failed, were dropped or were
+// cancelled on shutdown, and the history walk never returns to them, so the
+// messages are fetched by ID for fresh file references.
+func (f *Fetcher) RetryMissingMedia(ctx context.Context) error {
+	missing, err := f.database.MissingMedia(f.account.ID)
+	if err != nil {
+		return err
+	}
+
+	for chatID, messageIDs := range missing {
+		if err := f.retryChatMedia(ctx, chatID, messageIDs); err != nil {
+			if ctx.Err() != nil || isFloodWait(err) {
+				return err
+			}
+			log.Warn().Err(err).Str("chat_id", chatID.String()).Msg("Failed to retry missing media")
+		}
+	}
+	return nil
+}
+
+// RetryMediaLoop runs RetryMissingMedia every interval until ctx is
+// cancelled. Bots have no sync pass to run it after.
+func (f *Fetcher) RetryMediaLoop(ctx context.Context, interval time.Duration) {
+	for {
+		if err := f.RetryMissingMedia(ctx); err != nil && ctx.Err() == nil {
+			log.Error().Err(err).Msg("Failed to retry missing media")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (f *Fetcher) retryChatMedia(ctx context.Context, chatID uuid.UUID, messageIDs []int) error {
+	var chat db.Chat
+	if err := f.database.Conn.First(&chat, "id = ?", chatID).Error; err != nil {
+		return fmt.Errorf("failed to load chat %s: %w", chatID, err)
+	}
+
+	for start := 0; start < len(messageIDs); start += retryBatchSize {
+		ids := messageIDs[start:min(start+retryBatchSize, len(messageIDs))]
+		messages, err := f.getMessagesByID(ctx, &chat, ids)
+		if err != nil {
+			return err
+		}
+		if _, err := f.processMessagesBatch(ctx, messages, chat.Title, chat.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getMessagesByID fetches the given messages of chat. Messages deleted since
+// they were archived are left out.
+func (f *Fetcher) getMessagesByID(ctx context.Context, chat *db.Chat, ids []int) ([]tg.MessageClass, error) {
+	input := make([]tg.InputMessageClass, len(ids))
+	for i, id := range ids {
+		input[i] = &tg.InputMessageID{ID: id}
+	}
+
+	tgClient := tg.NewClient(f.client)
+	var (
+		res tg.MessagesMessagesClass
+		err error
+	)
+	if chat.PeerType == peerTypeChannel {
+		channel, chErr := f.inputChannel(ctx, chat.TelegramID)
+		if chErr != nil {
+			return nil, chErr
+		}
+		res, err = tgClient.ChannelsGetMessages(ctx, &tg.ChannelsGetMessagesRequest{Channel: channel, ID: input})
+	} else {
+		// Message IDs outside channels are unique per account, so no peer
+		// is needed.
+		res, err = tgClient.MessagesGetMessages(ctx, input)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch messages by ID: %w", err)
+	}
+
+	all, _ := unpackMessages(res)
+	messages := make([]tg.MessageClass, 0, len(all))
+	for _, msg := range all {
+		if _, ok := msg.(*tg.MessageEmpty); ok {
+			log.Debug().Int("message_id", msg.GetID()).Msg("Message with missing media was deleted")
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// inputChannel builds the input of a channel from the access hash the updates
+// manager stored for the bot; only bots archive channels.
+func (f *Fetcher) inputChannel(ctx context.Context, channelID int64) (*tg.InputChannel, error) {
+	var state db.UpdateChannelState
+	err := f.database.Conn.WithContext(ctx).
+		Where("telegram_user_id = ? AND channel_id = ? AND access_hash <> 0", f.myUserID, channelID).
+		First(&state).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("no access hash stored for channel %d", channelID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load access hash of channel %d: %w", channelID, err)
+	}
+	return &tg.InputChannel{ChannelID: channelID, AccessHash: state.AccessHash}, nil
+}
@@ -0,0 +1,101 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gotd/td/telegram/updates"
+	"github.com/gotd/td/tg"
+	"github.com/rs/zerolog/log"
+)
+
+const peerTypeChannel = "channel"
+
+// RegisterUpdateHandlers archives messages as they arrive through updates.
+// Bots cannot list dialogs or read history, so for bot accounts this is the
+// only source of messages. Handlers must be registered before the client runs.
+func (f *Fetcher) RegisterUpdateHandlers(d tg.UpdateDispatcher) {
+	d.OnNewMessage(func(ctx context.Context, e tg.Entities, u *tg.UpdateNewMessage) error {
+		return logUpdateError(f.handleUpdateMessage(ctx, e, u.Message))
+	})
+	d.OnNewChannelMessage(func(ctx context.Context, e tg.Entities, u *tg.UpdateNewChannelMessage) error {
+		return logUpdateError(f.handleUpdateMessage(ctx, e, u.Message))
+	})
+}
+
+// logUpdateError logs err before handing it back to gotd, which only reports
+// handler errors through its own logger and that one is disabled.
+func logUpdateError(err error) error {
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to archive update")
+	}
+	return err
+}
+
+func (f *Fetcher) handleUpdateMessage(ctx context.Context, e tg.Entities, msg tg.MessageClass) error {
+	m, ok := msg.(*tg.Message)
+	if !ok {
+		return nil
+	}
+
+	telegramID, peerType, dialogName, ok := updatePeer(e, m.PeerID)
+	if !ok {
+		log.Warn().
+			Str("peer_type", fmt.Sprintf("%T", m.PeerID)).
+			Msg("Skipping update from unsupported peer")
+		return nil
+	}
+
+	chat, err := f.upsertChat(telegramID, peerType, dialogName)
+	if err != nil {
+		return fmt.Errorf("store chat %d from update: %w", telegramID, err)
+	}
+
+	if _, err := f.processMessagesBatch(ctx, []tg.MessageClass{m}, dialogName, chat.ID); err != nil {
+		return fmt.Errorf("store message %d from update: %w", m.ID, err)
+	}
+	return nil
+}
+
+// updatePeer resolves the chat an update belongs to using the entities sent
+// along with it.
+func updatePeer(e tg.Entities, peer tg.PeerClass) (int64, string, string, bool) {
+	switch p := peer.(type) {
+	case *tg.PeerUser:
+		name := fmt.Sprintf("user%d", p.UserID)
+		if u, ok := e.Users[p.UserID]; ok && u.Username != "" {
+			name = u.Username
+		}
+		return p.UserID, peerTypeUser, name, true
+	case *tg.PeerChat:
+		name := fmt.Sprintf("chat%d", p.ChatID)
+		if c, ok := e.Chats[p.ChatID]; ok && c.Title != "" {
+			name = c.Title
+		}
+		return p.ChatID, peerTypeChat, name, true
+	case *tg.PeerChannel:
+		name := fmt.Sprintf("channel%d", p.ChannelID)
+		if c, ok := e.Channels[p.ChannelID]; ok && c.Title != "" {
+			name = c.Title
+		}
+		return p.ChannelID, peerTypeChannel, name, true
+	default:
+		return 0, "", "", false
+	}
+}
+
+// WaitForUpdates passes updates to the registered handlers through gaps until
+// ctx is cancelled. The manager orders them and fetches whatever was missed
+// since the stored state, including while the bot was offline.
+func (f *Fetcher) WaitForUpdates(ctx context.Context, gaps *updates.Manager) error {
+	err := gaps.Run(ctx, f.client.API(), f.myUserID, updates.AuthOptions{
+		IsBot: true,
+		OnStart: func(context.Context) {
+			log.Info().Msg("Listening for updates")
+		},
+	})
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("failed to receive updates: %w", err)
+	}
+	return nil
+}
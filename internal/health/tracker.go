@@ -0,0 +1,99 @@
+package health
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// State is the connection state of one Telegram account.
+type State string
+
+const (
+	StateStarting     State = "starting"
+	StateConnected    State = "connected"
+	StateReconnecting State = "reconnecting"
+	StateStopped      State = "stopped"
+)
+
+// AccountStatus describes the Telegram connection of one account.
+type AccountStatus struct {
+	Account   string    `json:"account"`
+	State     State     `json:"state"`
+	Since     time.Time `json:"since"`
+	Restarts  int       `json:"restarts"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// Tracker collects the connection state of every account syncing in this
+// process. A nil *Tracker is valid and records nothing, for commands that
+// don't serve HTTP.
+type Tracker struct {
+	mu       sync.RWMutex
+	accounts map[string]*AccountStatus
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{accounts: make(map[string]*AccountStatus)}
+}
+
+// Set records that account moved to state.
+func (t *Tracker) Set(account string, state State) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	status := t.status(account)
+	if status.State != state {
+		status.State = state
+		status.Since = time.Now()
+	}
+	if state == StateConnected {
+		status.LastError = ""
+	}
+}
+
+// Restarting records that the client of account stopped with err and is
+// about to be restarted.
+func (t *Tracker) Restarting(account string, err error) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	status := t.status(account)
+	status.State = StateReconnecting
+	status.Since = time.Now()
+	status.Restarts++
+	if err != nil {
+		status.LastError = err.Error()
+	}
+}
+
+// Accounts returns a snapshot of every tracked account, sorted by name.
+func (t *Tracker) Accounts() []AccountStatus {
+	if t == nil {
+		return nil
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	statuses := make([]AccountStatus, 0, len(t.accounts))
+	for _, status := range t.accounts {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Account < statuses[j].Account })
+	return statuses
+}
+
+func (t *Tracker) status(account string) *AccountStatus {
+	status, ok := t.accounts[account]
+	if !ok {
+		status = &AccountStatus{Account: account, State: StateStarting, Since: time.Now()}
+		t.accounts[account] = status
+	}
+	return status
+}
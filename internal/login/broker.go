@@ -0,0 +1,144 @@
+package login
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"rsc.io/qr"
+)
+
+type State string
+
+const (
+	StateIdle             State = "idle"
+	StateAwaitingPhone    State = "awaiting_phone"
+	StateAwaitingCode     State = "awaiting_code"
+	StateAwaitingPassword State = "awaiting_password"
+	StateAwaitingQR       State = "awaiting_qr"
+	StateAuthorized       State = "authorized"
+	StateFailed           State = "failed"
+)
+
+var (
+	ErrNotAwaiting = errors.New("the login flow is not waiting for this input")
+	ErrNoQRCode    = errors.New("no QR code is currently available")
+)
+
+type Status struct {
+	Account   string    `json:"account,omitempty"`
+	State     State     `json:"state"`
+	QRURL     string    `json:"qr_url,omitempty"`
+	QRExpires time.Time `json:"qr_expires,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Broker hands login input entered through the web UI to the auth flow
+// running in the Telegram client goroutine.
+type Broker struct {
+	mu      sync.Mutex
+	account string
+	status  Status
+	input   chan string
+	turn    chan struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		status: Status{State: StateIdle},
+		input:  make(chan string),
+		turn:   make(chan struct{}, 1),
+	}
+}
+
+// Acquire reserves the broker for the login flow of account. Logins of
+// several accounts are serialized so the page always shows which account
+// the input is for. The returned function releases the broker.
+func (b *Broker) Acquire(ctx context.Context, account string) (func(), error) {
+	select {
+	case b.turn <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	b.mu.Lock()
+	b.account = account
+	b.mu.Unlock()
+
+	return func() { <-b.turn }, nil
+}
+
+func (b *Broker) Status() Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.status
+}
+
+func (b *Broker) setStatus(s Status) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s.Account = b.account
+	b.status = s
+}
+
+func (b *Broker) WaitPhone(ctx context.Context) (string, error) {
+	return b.wait(ctx, StateAwaitingPhone)
+}
+
+func (b *Broker) WaitCode(ctx context.Context) (string, error) {
+	return b.wait(ctx, StateAwaitingCode)
+}
+
+func (b *Broker) WaitPassword(ctx context.Context) (string, error) {
+	return b.wait(ctx, StateAwaitingPassword)
+}
+
+func (b *Broker) wait(ctx context.Context, state State) (string, error) {
+	b.setStatus(Status{State: state})
+	select {
+	case v := <-b.input:
+		b.setStatus(Status{State: StateIdle})
+		return v, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// Submit delivers value if the flow is currently waiting for state.
+func (b *Broker) Submit(ctx context.Context, state State, value string) error {
+	if b.Status().State != state {
+		return ErrNotAwaiting
+	}
+	select {
+	case b.input <- value:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *Broker) ShowQR(url string, expires time.Time) {
+	b.setStatus(Status{State: StateAwaitingQR, QRURL: url, QRExpires: expires})
+}
+
+// QRPNG renders the current QR login token as a PNG image.
+func (b *Broker) QRPNG() ([]byte, error) {
+	s := b.Status()
+	if s.State != StateAwaitingQR || s.QRURL == "" {
+		return nil, ErrNoQRCode
+	}
+	code, err := qr.Encode(s.QRURL, qr.M)
+	if err != nil {
+		return nil, err
+	}
+	return code.PNG(), nil
+}
+
+func (b *Broker) SetAuthorized() {
+	b.setStatus(Status{State: StateAuthorized})
+}
+
+func (b *Broker) SetFailed(err error) {
+	b.setStatus(Status{State: StateFailed, Error: err.Error()})
+}
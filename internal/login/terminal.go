@@ -0,0 +1,41 @@
+package login
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"rsc.io/qr"
+)
+
+const quietZone = 2
+
+// PrintQR draws url as a QR code using half-block characters, two modules per
+// text row, so it can be scanned straight from a terminal. Light modules are
+// drawn as blocks, which suits the usual light-on-dark terminal.
+func PrintQR(w io.Writer, url string) error {
+	code, err := qr.Encode(url, qr.M)
+	if err != nil {
+		return fmt.Errorf("encode QR code: %w", err)
+	}
+
+	var sb strings.Builder
+	for y := -quietZone; y < code.Size+quietZone; y += 2 {
+		for x := -quietZone; x < code.Size+quietZone; x++ {
+			top, bottom := !code.Black(x, y), !code.Black(x, y+1)
+			switch {
+			case top && bottom:
+				sb.WriteRune('█')
+			case top:
+				sb.WriteRune('▀')
+			case bottom:
+				sb.WriteRune('▄')
+			default:
+				sb.WriteRune(' ')
+			}
+		}
+		sb.WriteByte('\n')
+	}
+	_, err = io.WriteString(w, sb.String())
+	return err
+}
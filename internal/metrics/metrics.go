@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "tmd"
+
+var (
+	MessagesArchived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_archived_total",
+		Help:      "Messages stored for the first time, per account and chat.",
+	}, []string{"account", "chat_id"})
+
+	LastMessageArchived = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_message_archived_timestamp_seconds",
+		Help:      "When the account last stored a new message.",
+	}, []string{"account"})
+
+	MediaJobs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "media_jobs_total",
+		Help:      "Media jobs by outcome: queued, succeeded or failed.",
+	}, []string{"account", "status"})
+
+	MediaBytesUploaded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "media_uploaded_bytes_total",
+		Help:      "Bytes of media uploaded to object storage.",
+	}, []string{"account"})
+
+	MediaQueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "media_queue_depth",
+		Help:      "Media jobs waiting for a worker.",
+	}, []string{"account"})
+
+	RPCDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "telegram_rpc_duration_seconds",
+		Help:      "Latency of Telegram RPC calls by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	FloodWaits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "telegram_flood_waits_total",
+		Help:      "FLOOD_WAIT errors returned by Telegram, by method.",
+	}, []string{"method"})
+
+	SyncDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "sync_duration_seconds",
+		Help:      "Duration of a full pass over all dialogs.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 14),
+	}, []string{"account"})
+
+	LastSyncSuccess = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_sync_success_timestamp_seconds",
+		Help:      "When the account last finished a pass over all dialogs without error.",
+	}, []string{"account"})
+
+	VerifyObjects = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "verify_objects",
+		Help:      "Objects checked by the last storage verification, and how many were missing, orphaned or corrupt.",
+	}, []string{"result"})
+
+	LastVerify = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_verify_timestamp_seconds",
+		Help:      "When the storage was last verified against the database.",
+	})
+)
@@ -3,95 +3,176 @@ package internal
 import (
 	"context"
 	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
-	"tmd/internal/web"
-	"tmd/pkg/minio"
 
-	"tmd/internal/db"
-	"tmd/internal/fetcher"
-	"tmd/pkg/cfg"
-	"tmd/pkg/filehandler"
-	"tmd/pkg/logger"
-
-	"github.com/gotd/td/telegram"
-	"github.com/rs/zerolog/log"
+	"tmd/internal/health"
 )
 
-func Run() error {
-	if err := logger.SetupLogger("tmd.log", "info"); err != nil {
-		log.Fatal().Err(err).Msg("Failed to setup logger")
+const syncInterval = 5 * time.Minute
+
+func signalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// Run starts the HTTP API and the sync loop in a single process.
+func Run(opts Options) error {
+	config, err := loadConfig(opts)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	dbConn, err := openDB(config, true)
+	if err != nil {
+		return err
+	}
+	defer closeDB(dbConn)
+
+	st, err := openStorage(config, true)
+	if err != nil {
+		return err
+	}
+
+	accounts, err := selectAccounts(config, opts.Account)
+	if err != nil {
+		return err
+	}
+
+	broker := newLoginBroker(config)
+	tracker := health.NewTracker()
+	srv, err := startHTTPServer(config, dbConn, st, broker, tracker, stop)
+	if err != nil {
+		return err
+	}
+	defer shutdownHTTP(srv, config.Web.ShutdownTimeout)
+	startVerifier(ctx, config, dbConn, st)
+
+	return syncAccounts(ctx, config, accounts, dbConn, st, broker, tracker, false)
+}
+
+// Sync archives Telegram without serving the API. With once set it performs a
+// single pass over all dialogs and exits.
+func Sync(opts Options, once bool) error {
+	config, err := loadConfig(opts)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	dbConn, err := openDB(config, true)
+	if err != nil {
+		return err
+	}
+	defer closeDB(dbConn)
+
+	st, err := openStorage(config, true)
+	if err != nil {
+		return err
 	}
 
-	config, err := cfg.LoadConfig("config.yaml")
+	accounts, err := selectAccounts(config, opts.Account)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to read config.yaml")
 		return err
 	}
 
-	dbConn, err := db.NewDB(config)
+	broker := newLoginBroker(config)
+	stopLogin, err := startLoginServer(config, broker, stop)
+	if err != nil {
+		return err
+	}
+	defer stopLogin()
+	var tracker *health.Tracker
+	if !once {
+		tracker = health.NewTracker()
+		defer startMetricsServer(config, dbConn, st, tracker, stop)()
+		startVerifier(ctx, config, dbConn, st)
+	}
+
+	return syncAccounts(ctx, config, accounts, dbConn, st, broker, tracker, once)
+}
+
+// Serve runs only the HTTP API. It expects the schema to be migrated by
+// `tmd migrate` or a syncer, so several replicas can start concurrently.
+func Serve(opts Options) error {
+	config, err := loadConfig(opts)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to connect to DB")
 		return err
 	}
 
-	st, err := minio.NewStorage(
-		config.Minio.Endpoint,
-		config.Minio.AccessKey,
-		config.Minio.SecretKey,
-		config.Minio.Bucket,
-		config.Minio.BasePath,
-		config.Minio.UseSSL,
-	)
+	ctx, stop := signalContext()
+	defer stop()
+
+	dbConn, err := openDB(config, false)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to create MinIO storage")
 		return err
 	}
+	defer closeDB(dbConn)
 
-	client := telegram.NewClient(
-		config.Telegram.ApiID,
-		config.Telegram.ApiHash,
-		telegram.Options{},
-	)
+	st, err := openStorage(config, false)
+	if err != nil {
+		return err
+	}
 
-	downloader := filehandler.NewDownloader(client, config.Download.BaseDir)
-	f := fetcher.NewFetcher(
-		client,
-		downloader,
-		dbConn,
-		st,
-		config.Fetching.DialogsLimit,
-		config.Fetching.MessagesLimit,
-	)
-	go func() {
-		handler := web.NewHandler(dbConn, st)
-		router := web.SetupRouter(handler)
+	srv, err := startHTTPServer(config, dbConn, st, nil, nil, stop)
+	if err != nil {
+		return err
+	}
+	<-ctx.Done()
+	shutdownHTTP(srv, config.Web.ShutdownTimeout)
+	return nil
+}
 
-		if err := router.Run(":8083"); err != nil {
-			log.Fatal().Err(err).Msg("Failed to run HTTP server")
+// Migrate applies (up), reverts (down) or lists (status) schema migrations
+// and exits. Down reverts the given number of steps.
+func Migrate(opts Options, action string, steps int) error {
+	config, err := loadConfig(opts)
+	if err != nil {
+		return err
+	}
+
+	dbConn, err := openDB(config, false)
+	if err != nil {
+		return err
+	}
+	defer closeDB(dbConn)
+
+	switch action {
+	case "up":
+		applied, err := dbConn.MigrateUp()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Applied %d migration(s), database schema is up to date\n", applied)
+	case "down":
+		if steps < 1 {
+			return fmt.Errorf("--steps must be at least 1")
 		}
-	}()
-	return client.Run(context.Background(), func(ctx context.Context) error {
-		if err := EnsureAuth(ctx, client, config); err != nil {
+		reverted, err := dbConn.MigrateDown(steps)
+		if err != nil {
 			return err
 		}
-
-		self, err := client.Self(ctx)
+		fmt.Printf("Reverted %d migration(s)\n", reverted)
+	case "status":
+		statuses, err := dbConn.MigrationStatus()
 		if err != nil {
-			return fmt.Errorf("failed to get self user: %w", err)
+			return err
 		}
-		f.SetMyUserID(self.ID)
-		log.Info().Msg("Client is authorized and ready!")
-
-		go func() {
-			defer f.CloseWorkers()
-			for {
-				if err := f.FetchAllDMs(ctx); err != nil {
-					log.Error().Err(err).Msg("Failed to fetch DMs")
-				}
-				time.Sleep(5 * time.Minute)
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = "applied " + s.AppliedAt.Format(time.RFC3339)
 			}
-		}()
-
-		select {}
-	})
+			fmt.Printf("%04d  %-24s %s\n", s.Version, s.Name, applied)
+		}
+	default:
+		return fmt.Errorf("unknown migrate action %q, expected up, down or status", action)
+	}
+	return nil
 }
@@ -0,0 +1,50 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"tmd/internal/db"
+
+	tgsession "github.com/gotd/td/session"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DBStorage keeps the session in the sessions table, keyed by account name.
+type DBStorage struct {
+	database *db.DB
+	name     string
+}
+
+func (s *DBStorage) LoadSession(ctx context.Context) ([]byte, error) {
+	var record db.Session
+	err := s.database.Conn.WithContext(ctx).Where("name = ?", s.name).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, tgsession.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query session: %w", err)
+	}
+	return record.Data, nil
+}
+
+func (s *DBStorage) StoreSession(ctx context.Context, data []byte) error {
+	record := db.Session{Name: s.name, Data: data}
+	err := s.database.Conn.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"data", "updated_at"}),
+	}).Create(&record).Error
+	if err != nil {
+		return fmt.Errorf("store session: %w", err)
+	}
+	return nil
+}
+
+func (s *DBStorage) Delete(ctx context.Context) error {
+	if err := s.database.Conn.WithContext(ctx).Where("name = ?", s.name).Delete(&db.Session{}).Error; err != nil {
+		return fmt.Errorf("delete session: %w", err)
+	}
+	return nil
+}
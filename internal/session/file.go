@@ -0,0 +1,51 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	tgsession "github.com/gotd/td/session"
+)
+
+// FileStorage keeps the session in a single file readable only by the owner.
+type FileStorage struct {
+	path string
+	mux  sync.Mutex
+}
+
+func (f *FileStorage) LoadSession(_ context.Context) ([]byte, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, tgsession.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read session file: %w", err)
+	}
+	return data, nil
+}
+
+func (f *FileStorage) StoreSession(_ context.Context, data []byte) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	if err := os.WriteFile(f.path, data, 0o600); err != nil {
+		return fmt.Errorf("write session file: %w", err)
+	}
+	return nil
+}
+
+func (f *FileStorage) Delete(_ context.Context) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	if err := os.Remove(f.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove session file: %w", err)
+	}
+	return nil
+}
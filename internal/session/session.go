@@ -0,0 +1,171 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"tmd/internal/db"
+	"tmd/pkg/cfg"
+
+	tgsession "github.com/gotd/td/session"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	BackendDatabase = "database"
+	BackendFile     = "file"
+)
+
+// Storage persists the Telegram session between restarts and can wipe it on
+// logout.
+type Storage interface {
+	tgsession.Storage
+	Delete(ctx context.Context) error
+}
+
+// New builds the storage selected by telegram.session.backend for account.
+// database may be nil unless the database backend is used.
+func New(config *cfg.Config, account cfg.TelegramAccount, database *db.DB) (Storage, error) {
+	sc := config.Telegram.Session
+
+	var storage Storage
+	switch sc.Backend {
+	case BackendDatabase:
+		if database == nil {
+			return nil, errors.New("database session backend requires a database connection")
+		}
+		storage = &DBStorage{database: database, name: account.Name}
+	case BackendFile:
+		path := sc.Path
+		if len(config.Telegram.Accounts) > 1 {
+			path = fmt.Sprintf("%s.%s", sc.Path, account.Name)
+		}
+		storage = &FileStorage{path: path}
+	default:
+		return nil, fmt.Errorf("unsupported session backend: %s", sc.Backend)
+	}
+
+	if sc.Key == "" {
+		return storage, nil
+	}
+	return newEncrypted(storage, sc.Key), nil
+}
+
+// Sealed sessions start with sessionMagic and the scrypt salt, followed by
+// the GCM nonce and ciphertext.
+const (
+	sessionMagic = "TMDS"
+	saltSize     = 16
+)
+
+// encrypted seals session data with AES-GCM before handing it to the
+// underlying storage. The key is derived from the passphrase with scrypt and
+// cached for the salt last seen, since scrypt is deliberately slow.
+type encrypted struct {
+	Storage
+	passphrase []byte
+
+	mux  sync.Mutex
+	salt []byte
+	aead cipher.AEAD
+}
+
+func newEncrypted(storage Storage, key string) *encrypted {
+	return &encrypted{Storage: storage, passphrase: []byte(key)}
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("session cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("session cipher: %w", err)
+	}
+	return aead, nil
+}
+
+// cipherFor returns the cipher for salt. e.mux must be held.
+func (e *encrypted) cipherFor(salt []byte) (cipher.AEAD, error) {
+	if e.aead != nil && bytes.Equal(salt, e.salt) {
+		return e.aead, nil
+	}
+	key, err := scrypt.Key(e.passphrase, salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, fmt.Errorf("derive session key: %w", err)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	e.salt, e.aead = bytes.Clone(salt), aead
+	return aead, nil
+}
+
+func (e *encrypted) LoadSession(ctx context.Context) ([]byte, error) {
+	data, err := e.Storage.LoadSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var aead cipher.AEAD
+	if len(data) >= len(sessionMagic)+saltSize && string(data[:len(sessionMagic)]) == sessionMagic {
+		e.mux.Lock()
+		aead, err = e.cipherFor(data[len(sessionMagic) : len(sessionMagic)+saltSize])
+		e.mux.Unlock()
+		data = data[len(sessionMagic)+saltSize:]
+	} else {
+		// Older releases used the unsalted SHA-256 of the passphrase. The
+		// next StoreSession rewrites the session with a derived key.
+		sum := sha256.Sum256(e.passphrase)
+		aead, err = newAEAD(sum[:])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := aead.NonceSize()
+	if len(data) < nonceSize {
+		return nil, errors.New("session data is too short")
+	}
+	plain, err := aead.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt session (wrong telegram.session.key?): %w", err)
+	}
+	return plain, nil
+}
+
+func (e *encrypted) StoreSession(ctx context.Context, data []byte) error {
+	e.mux.Lock()
+	if e.salt == nil {
+		salt := make([]byte, saltSize)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			e.mux.Unlock()
+			return fmt.Errorf("session salt: %w", err)
+		}
+		e.salt = salt
+	}
+	salt := e.salt
+	aead, err := e.cipherFor(salt)
+	e.mux.Unlock()
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return fmt.Errorf("session nonce: %w", err)
+	}
+	sealed := append([]byte(sessionMagic), salt...)
+	sealed = append(sealed, nonce...)
+	return e.Storage.StoreSession(ctx, aead.Seal(sealed, nonce, data, nil))
+}
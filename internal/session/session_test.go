@@ -0,0 +1,88 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncrypted(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "tmd.session")
+	file := &FileStorage{path: path}
+	data := []byte(`{"Version":1,"Data":{}}`)
+
+	if err := newEncrypted(file, "passphrase").StoreSession(ctx, data); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := file.LoadSession(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(stored, []byte(sessionMagic)) || bytes.Contains(stored, data) {
+		t.Fatalf("stored session is not sealed: %q", stored)
+	}
+	got, err := newEncrypted(file, "passphrase").LoadSession(ctx)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("LoadSession = %q, %v", got, err)
+	}
+	if _, err := newEncrypted(file, "other").LoadSession(ctx); err == nil {
+		t.Error("loaded the session with the wrong passphrase")
+	}
+
+	// The same passphrase must not give the same key for another session.
+	other := &FileStorage{path: path + ".other"}
+	if err := newEncrypted(other, "passphrase").StoreSession(ctx, data); err != nil {
+		t.Fatal(err)
+	}
+	otherStored, err := other.LoadSession(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	salt := stored[len(sessionMagic) : len(sessionMagic)+saltSize]
+	if bytes.Equal(salt, otherStored[len(sessionMagic):len(sessionMagic)+saltSize]) {
+		t.Error("two sessions share a salt")
+	}
+}
+
+func TestEncryptedLegacy(t *testing.T) {
+	ctx := context.Background()
+	file := &FileStorage{path: filepath.Join(t.TempDir(), "tmd.session")}
+	data := []byte(`{"Version":1,"Data":{}}`)
+
+	// Written by a release that used the unsalted SHA-256 of the passphrase.
+	sum := sha256.Sum256([]byte("passphrase"))
+	aead, err := newAEAD(sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.StoreSession(ctx, aead.Seal(nonce, nonce, data, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	e := newEncrypted(file, "passphrase")
+	got, err := e.LoadSession(ctx)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("LoadSession = %q, %v", got, err)
+	}
+	if err := e.StoreSession(ctx, data); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := file.LoadSession(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(stored, []byte(sessionMagic)) {
+		t.Error("legacy session was not rewritten with a derived key")
+	}
+	if got, err := newEncrypted(file, "passphrase").LoadSession(ctx); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("LoadSession after rewrite = %q, %v", got, err)
+	}
+}
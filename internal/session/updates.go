@@ -0,0 +1,160 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"tmd/internal/db"
+
+	"github.com/gotd/td/telegram/updates"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// UpdatesStorage keeps the update state of gotd's updates.Manager in the
+// update_states and update_channel_states tables, so a restarted bot asks
+// Telegram for the difference instead of starting from the current state.
+type UpdatesStorage struct {
+	database *db.DB
+}
+
+var (
+	_ updates.StateStorage        = (*UpdatesStorage)(nil)
+	_ updates.ChannelAccessHasher = (*UpdatesStorage)(nil)
+)
+
+func NewUpdatesStorage(database *db.DB) *UpdatesStorage {
+	return &UpdatesStorage{database: database}
+}
+
+func (s *UpdatesStorage) GetState(ctx context.Context, userID int64) (updates.State, bool, error) {
+	var record db.UpdateState
+	err := s.database.Conn.WithContext(ctx).Where("telegram_user_id = ?", userID).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return updates.State{}, false, nil
+	}
+	if err != nil {
+		return updates.State{}, false, fmt.Errorf("query update state: %w", err)
+	}
+	return updates.State{Pts: record.Pts, Qts: record.Qts, Date: record.Date, Seq: record.Seq}, true, nil
+}
+
+func (s *UpdatesStorage) SetState(ctx context.Context, userID int64, state updates.State) error {
+	record := db.UpdateState{
+		TelegramUserID: userID,
+		Pts:            state.Pts,
+		Qts:            state.Qts,
+		Date:           state.Date,
+		Seq:            state.Seq,
+	}
+	err := s.database.Conn.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "telegram_user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"pts", "qts", "date", "seq", "updated_at"}),
+	}).Create(&record).Error
+	if err != nil {
+		return fmt.Errorf("store update state: %w", err)
+	}
+	return nil
+}
+
+func (s *UpdatesStorage) SetPts(ctx context.Context, userID int64, pts int) error {
+	return s.updateState(ctx, userID, map[string]any{"pts": pts})
+}
+
+func (s *UpdatesStorage) SetQts(ctx context.Context, userID int64, qts int) error {
+	return s.updateState(ctx, userID, map[string]any{"qts": qts})
+}
+
+func (s *UpdatesStorage) SetDate(ctx context.Context, userID int64, date int) error {
+	return s.updateState(ctx, userID, map[string]any{"date": date})
+}
+
+func (s *UpdatesStorage) SetSeq(ctx context.Context, userID int64, seq int) error {
+	return s.updateState(ctx, userID, map[string]any{"seq": seq})
+}
+
+func (s *UpdatesStorage) SetDateSeq(ctx context.Context, userID int64, date, seq int) error {
+	return s.updateState(ctx, userID, map[string]any{"date": date, "seq": seq})
+}
+
+// updateState changes part of a stored state. The manager always stores the
+// whole state first, so a missing one is an error.
+func (s *UpdatesStorage) updateState(ctx context.Context, userID int64, values map[string]any) error {
+	res := s.database.Conn.WithContext(ctx).
+		Model(&db.UpdateState{}).
+		Where("telegram_user_id = ?", userID).
+		Updates(values)
+	if res.Error != nil {
+		return fmt.Errorf("update update state: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("no update state stored for user %d", userID)
+	}
+	return nil
+}
+
+func (s *UpdatesStorage) GetChannelPts(ctx context.Context, userID, channelID int64) (int, bool, error) {
+	record, found, err := s.channelState(ctx, userID, channelID)
+	if !found || record.Pts == 0 {
+		return 0, false, err
+	}
+	return record.Pts, true, nil
+}
+
+func (s *UpdatesStorage) SetChannelPts(ctx context.Context, userID, channelID int64, pts int) error {
+	return s.storeChannelState(ctx, db.UpdateChannelState{TelegramUserID: userID, ChannelID: channelID, Pts: pts}, "pts")
+}
+
+func (s *UpdatesStorage) ForEachChannels(ctx context.Context, userID int64, f func(ctx context.Context, channelID int64, pts int) error) error {
+	var records []db.UpdateChannelState
+	if err := s.database.Conn.WithContext(ctx).Where("telegram_user_id = ? AND pts > 0", userID).Find(&records).Error; err != nil {
+		return fmt.Errorf("query channel update states: %w", err)
+	}
+	for _, record := range records {
+		if err := f(ctx, record.ChannelID, record.Pts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *UpdatesStorage) GetChannelAccessHash(ctx context.Context, userID, channelID int64) (int64, bool, error) {
+	record, found, err := s.channelState(ctx, userID, channelID)
+	if !found || record.AccessHash == 0 {
+		return 0, false, err
+	}
+	return record.AccessHash, true, nil
+}
+
+func (s *UpdatesStorage) SetChannelAccessHash(ctx context.Context, userID, channelID, accessHash int64) error {
+	return s.storeChannelState(ctx, db.UpdateChannelState{TelegramUserID: userID, ChannelID: channelID, AccessHash: accessHash}, "access_hash")
+}
+
+func (s *UpdatesStorage) channelState(ctx context.Context, userID, channelID int64) (db.UpdateChannelState, bool, error) {
+	var record db.UpdateChannelState
+	err := s.database.Conn.WithContext(ctx).
+		Where("telegram_user_id = ? AND channel_id = ?", userID, channelID).
+		First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return record, false, nil
+	}
+	if err != nil {
+		return record, false, fmt.Errorf("query channel update state: %w", err)
+	}
+	return record, true, nil
+}
+
+// storeChannelState upserts record, changing only column of an existing row;
+// pts and the access hash are set independently, and a zero one counts as
+// unknown.
+func (s *UpdatesStorage) storeChannelState(ctx context.Context, record db.UpdateChannelState, column string) error {
+	err := s.database.Conn.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "telegram_user_id"}, {Name: "channel_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{column, "updated_at"}),
+	}).Create(&record).Error
+	if err != nil {
+		return fmt.Errorf("store channel update state: %w", err)
+	}
+	return nil
+}
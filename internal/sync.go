@@ -0,0 +1,300 @@
+package internal
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"tmd/internal/db"
+	"tmd/internal/fetcher"
+	"tmd/internal/health"
+	"tmd/internal/login"
+	"tmd/internal/session"
+	"tmd/pkg/cfg"
+	"tmd/pkg/errors"
+	"tmd/pkg/filehandler"
+	"tmd/pkg/storage"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/telegram/updates"
+	"github.com/gotd/td/tg"
+	"github.com/rs/zerolog/log"
+)
+
+// accountSync archives a single Telegram account with its own client,
+// session and fetcher.
+type accountSync struct {
+	config  *cfg.Config
+	account cfg.TelegramAccount
+	record  *db.Account
+	dbConn  *db.DB
+	st      storage.Storage
+	storage session.Storage
+	broker  *login.Broker
+	tracker *health.Tracker
+	// limiters are shared with the other accounts.
+	limiters *rateLimiters
+}
+
+// syncAccounts archives every account concurrently until ctx is cancelled.
+// An account that stops with an error does not stop the others. tracker may be
+// nil.
+func syncAccounts(
+	ctx context.Context,
+	config *cfg.Config,
+	accounts []cfg.TelegramAccount,
+	dbConn *db.DB,
+	st storage.Storage,
+	broker *login.Broker,
+	tracker *health.Tracker,
+	once bool,
+) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(accounts))
+	limiters := newRateLimiters(config)
+	for i, account := range accounts {
+		s, err := newAccountSync(config, account, dbConn, st, broker, tracker, limiters)
+		if err != nil {
+			errs[i] = fmt.Errorf("account %q: %w", account.Name, err)
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.run(ctx, once); err != nil {
+				log.Error().Err(err).Str("account", account.Name).Msg("Account sync stopped")
+				errs[i] = fmt.Errorf("account %q: %w", account.Name, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return stderrors.Join(errs...)
+}
+
+func newAccountSync(
+	config *cfg.Config,
+	account cfg.TelegramAccount,
+	dbConn *db.DB,
+	st storage.Storage,
+	broker *login.Broker,
+	tracker *health.Tracker,
+	limiters *rateLimiters,
+) (*accountSync, error) {
+	record, err := dbConn.EnsureAccount(account.Name)
+	if err != nil {
+		return nil, err
+	}
+	// Messages archived before accounts existed belong to the account that
+	// used to be the only one.
+	if account.Name == config.Telegram.Accounts[0].Name {
+		if err := dbConn.AdoptLegacyMessages(record.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	storage, err := session.New(config, account, dbConn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &accountSync{
+		config:   config,
+		account:  account,
+		record:   record,
+		dbConn:   dbConn,
+		st:       st,
+		storage:  storage,
+		broker:   broker,
+		tracker:  tracker,
+		limiters: limiters,
+	}, nil
+}
+
+// objectPrefix keeps media of different accounts apart in the bucket. A
+// single-account deployment keeps the original layout.
+func (s *accountSync) objectPrefix() string {
+	if len(s.config.Telegram.Accounts) == 1 {
+		return ""
+	}
+	return strings.ReplaceAll(s.account.Name, "/", "-")
+}
+
+// run archives the account until ctx is cancelled. When Telegram reports that
+// the session was revoked, the stored session is wiped and the login flow
+// runs again with a fresh client. In continuous mode any other failure, such
+// as a dropped connection, restarts the client with exponential backoff;
+// only errors that a retry can't fix stop the account.
+func (s *accountSync) run(ctx context.Context, once bool) error {
+	defer s.tracker.Set(s.account.Name, health.StateStopped)
+
+	restart := newRestartBackoff()
+	for {
+		started := time.Now()
+		err := s.runSession(ctx, once)
+		if ctx.Err() != nil {
+			return err
+		}
+
+		if errors.IsReauthRequired(err) {
+			log.Warn().Err(err).Str("account", s.account.Name).Msg("Telegram session is no longer valid; logging in again")
+			if err := s.storage.Delete(ctx); err != nil {
+				return err
+			}
+			continue
+		}
+		if once || err == nil || errors.IsPermanent(err) {
+			return err
+		}
+
+		if time.Since(started) > healthySessionDuration {
+			restart.Reset()
+		}
+		delay := restart.NextBackOff()
+		s.tracker.Restarting(s.account.Name, err)
+		log.Error().
+			Err(err).
+			Str("account", s.account.Name).
+			Dur("retry_in", delay).
+			Msg("Telegram client stopped; restarting")
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+// runWithClient calls fn through run, a client's Run method. The client runs on
+// its own context so that media workers can still download from Telegram
+// while draining after a shutdown signal, but fn stops when either ctx is
+// cancelled or the client stops.
+func runWithClient(
+	ctx context.Context,
+	run func(context.Context, func(context.Context) error) error,
+	fn func(context.Context) error,
+) error {
+	return run(context.Background(), func(clientCtx context.Context) error {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		defer context.AfterFunc(clientCtx, cancel)()
+		return fn(ctx)
+	})
+}
+
+// healthySessionDuration is how long a client has to run before its next
+// failure counts as a fresh one rather than part of a crash loop.
+const healthySessionDuration = 5 * time.Minute
+
+func newRestartBackoff() backoff.BackOff {
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = time.Second
+	b.MaxInterval = 5 * time.Minute
+	b.MaxElapsedTime = 0
+	return b
+}
+
+func (s *accountSync) runSession(ctx context.Context, once bool) error {
+	config := s.config
+	dispatcher := tg.NewUpdateDispatcher()
+	authenticator := NewAuthenticator(config, s.account, s.broker, dispatcher)
+	// Bots archive from updates, so gaps in the update stream are filled in by
+	// gotd's manager from the state stored in the database.
+	var handler telegram.UpdateHandler = dispatcher
+	var gaps *updates.Manager
+	if s.account.IsBot() {
+		store := session.NewUpdatesStorage(s.dbConn)
+		gaps = updates.New(updates.Config{
+			Handler:      dispatcher,
+			Storage:      store,
+			AccessHasher: store,
+			OnChannelTooLong: func(channelID int64) {
+				log.Warn().
+					Str("account", s.account.Name).
+					Int64("channel_id", channelID).
+					Msg("Too many missed channel updates to catch up; some messages were not archived")
+			},
+		})
+		handler = gaps
+	}
+	client := newTelegramClient(config, s.limiters, s.storage, handler)
+
+	downloader := filehandler.NewDownloader(client, config.Download.BaseDir)
+	f := fetcher.NewFetcher(
+		client,
+		downloader,
+		s.dbConn,
+		s.st,
+		s.record,
+		fetcher.Options{
+			ObjectPrefix:  s.objectPrefix(),
+			DialogsLimit:  config.Fetching.DialogsLimit,
+			MessagesLimit: config.Fetching.MessagesLimit,
+			DialogWorkers: config.Fetching.DialogWorkers,
+			MediaWorkers:  config.Fetching.MediaWorkers,
+		},
+	)
+	defer f.CloseWorkers(config.Fetching.DrainTimeout)
+	if s.account.IsBot() {
+		f.RegisterUpdateHandlers(dispatcher)
+	}
+
+	return runWithClient(ctx, client.Run, func(ctx context.Context) error {
+		if err := authenticator.EnsureAuth(ctx, client); err != nil {
+			return err
+		}
+
+		self, err := client.Self(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get self user: %w", errors.HandleTGError(err))
+		}
+		f.SetMyUserID(self.ID)
+		if err := s.dbConn.UpdateAccountIdentity(s.record, self.ID, self.Username); err != nil {
+			return err
+		}
+		s.tracker.Set(s.account.Name, health.StateConnected)
+		log.Info().Str("account", s.account.Name).Msg("Client is authorized and ready!")
+
+		switch {
+		case s.account.IsBot():
+			// Bots can't list dialogs or read history; they only see what
+			// Telegram pushes to them.
+			if once {
+				return fmt.Errorf("bot accounts can only be archived continuously; run without --once")
+			}
+			retryCtx, stopRetries := context.WithCancel(ctx)
+			retried := make(chan struct{})
+			go func() {
+				defer close(retried)
+				f.RetryMediaLoop(retryCtx, syncInterval)
+			}()
+			err := f.WaitForUpdates(ctx, gaps)
+			// The loop queues media jobs, so it must be done before the
+			// workers are closed.
+			stopRetries()
+			<-retried
+			if err != nil {
+				return errors.HandleTGError(err)
+			}
+			log.Info().Msg("Shutdown requested; draining media jobs")
+		case once:
+			if err := f.FetchAllDMs(ctx); err != nil {
+				return fmt.Errorf("failed to fetch DMs: %w", errors.HandleTGError(err))
+			}
+			log.Info().Msg("Single sync pass finished; draining media jobs")
+		default:
+			if err := f.SyncLoop(ctx, syncInterval); err != nil {
+				return err
+			}
+			log.Info().Msg("Shutdown requested; draining media jobs")
+		}
+
+		f.CloseWorkers(config.Fetching.DrainTimeout)
+		return nil
+	})
+}
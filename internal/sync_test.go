@@ -0,0 +1,59 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"tmd/pkg/errors"
+)
+
+func TestRunWithClient(t *testing.T) {
+	t.Run("client fails", func(t *testing.T) {
+		// Like a client whose connection fails for good while fn is running.
+		run := func(ctx context.Context, f func(context.Context) error) error {
+			clientCtx, cancel := context.WithCancel(ctx)
+			done := make(chan error, 1)
+			go func() { done <- f(clientCtx) }()
+			cancel()
+			if err := <-done; err != context.Canceled {
+				t.Errorf("fn returned %v, want context.Canceled", err)
+			}
+			return errors.ErrPhoneNumberBanned
+		}
+		err := runWithClient(context.Background(), run, func(ctx context.Context) error {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(5 * time.Second):
+				t.Error("fn was not cancelled when the client stopped")
+				return nil
+			}
+		})
+		if !errors.IsPermanent(err) {
+			t.Errorf("runWithClient = %v, want the client's permanent error", err)
+		}
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		run := func(ctx context.Context, f func(context.Context) error) error {
+			if ctx.Err() != nil {
+				t.Error("the client was started on the shutdown context")
+			}
+			err := f(ctx)
+			if ctx.Err() != nil {
+				t.Error("shutdown stopped the client")
+			}
+			return err
+		}
+		err := runWithClient(ctx, run, func(ctx context.Context) error {
+			cancel()
+			<-ctx.Done()
+			return nil
+		})
+		if err != nil {
+			t.Errorf("runWithClient = %v", err)
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"tmd/internal/db"
+	"tmd/internal/verify"
+	"tmd/pkg/cfg"
+	"tmd/pkg/storage"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Verify compares the media in storage with the database, prints every
+// missing, orphaned or corrupt object and repairs what vopts allow. It fails
+// when problems were found, so it can alert from cron.
+func Verify(opts Options, vopts verify.Options) error {
+	config, err := loadConfig(opts)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	dbConn, err := openDB(config, false)
+	if err != nil {
+		return err
+	}
+	defer closeDB(dbConn)
+
+	st, err := openStorage(config, false)
+	if err != nil {
+		return err
+	}
+
+	vopts.OrphanGrace = *config.Verify.OrphanGrace
+	report, err := verify.Run(ctx, dbConn, st, vopts)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range report.Missing {
+		fmt.Printf("[missing]  %s (message %s)\n", p.ObjectName, p.MessageID)
+	}
+	for _, p := range report.Corrupt {
+		fmt.Printf("[corrupt]  %s: %s (message %s)\n", p.ObjectName, p.Reason, p.MessageID)
+	}
+	for _, p := range report.Orphaned {
+		fmt.Printf("[orphaned] %s\n", p.ObjectName)
+	}
+	fmt.Printf("Checked %d object(s): %d missing, %d corrupt, %d orphaned; %d download(s) requeued, %d orphan(s) deleted\n",
+		report.Checked, len(report.Missing), len(report.Corrupt), len(report.Orphaned), report.Requeued, report.Deleted)
+	if report.Unrequeued > 0 {
+		fmt.Printf("%d message(s) predate accounts and can't be requeued\n", report.Unrequeued)
+	}
+
+	if n := report.Problems(); n > 0 {
+		return fmt.Errorf("%d problem(s) found", n)
+	}
+	return nil
+}
+
+// startVerifier verifies the storage every verify.interval until ctx is
+// cancelled. It does nothing when no interval is configured.
+func startVerifier(ctx context.Context, config *cfg.Config, dbConn *db.DB, st storage.Storage) {
+	if config.Verify.Interval == 0 {
+		return
+	}
+	vopts := verify.Options{
+		Checksums:      config.Verify.Checksums,
+		RequeueMissing: config.Verify.RequeueMissing,
+		DeleteOrphans:  config.Verify.DeleteOrphans,
+		OrphanGrace:    *config.Verify.OrphanGrace,
+	}
+	go func() {
+		ticker := time.NewTicker(config.Verify.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := verify.Run(ctx, dbConn, st, vopts); err != nil && ctx.Err() == nil {
+					log.Error().Err(err).Msg("Failed to verify storage")
+				}
+			}
+		}
+	}()
+}
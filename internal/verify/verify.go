@@ -0,0 +1,273 @@
+// Package verify compares the media recorded in the database with the objects
+// in storage.
+package verify
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+	"tmd/internal/db"
+	"tmd/internal/metrics"
+	"tmd/pkg/storage"
+)
+
+const batchSize = 1000
+
+// Options choose how thorough a verification is and what it repairs.
+type Options struct {
+	// Checksums reads every object and compares it with the SHA-256 recorded
+	// in its metadata. Without it only presence and size are checked.
+	Checksums bool
+	// RequeueMissing downloads the media of missing and corrupt objects
+	// again with the next media retry pass of their account.
+	RequeueMissing bool
+	// DeleteOrphans removes objects no message refers to. Objects newer than
+	// OrphanGrace are left alone, as their upload may still be recorded.
+	DeleteOrphans bool
+	OrphanGrace   time.Duration
+}
+
+// Problem is an object that is missing, orphaned or corrupt.
+type Problem struct {
+	ObjectName string
+	Reason     string
+	// MessageID is the database ID of the message referring to the object,
+	// or nil for orphans.
+	MessageID *uuid.UUID
+}
+
+type Report struct {
+	Checked  int
+	Missing  []Problem
+	Orphaned []Problem
+	Corrupt  []Problem
+	// Unrequeued counts broken media that can't be downloaded again because
+	// the message was archived before accounts were recorded.
+	Unrequeued int
+	Requeued   int
+	Deleted    int
+}
+
+// Problems returns how many problems were found.
+func (r *Report) Problems() int {
+	return len(r.Missing) + len(r.Orphaned) + len(r.Corrupt)
+}
+
+type mediaRef struct {
+	ID        uuid.UUID
+	ChatID    uuid.UUID
+	AccountID *uuid.UUID
+	MessageID int
+}
+
+// Run lists the storage, compares it with the media URLs in the database and
+// repairs what opts allow.
+func Run(ctx context.Context, database *db.DB, st storage.Storage, opts Options) (*Report, error) {
+	started := time.Now()
+	refs := make(map[string][]mediaRef)
+	var batch []db.Message
+	err := database.Conn.WithContext(ctx).
+		Select("id", "chat_id", "account_id", "message_id", "media_url").
+		Where("media_url <> ''").
+		FindInBatches(&batch, batchSize, func(*gorm.DB, int) error {
+			for _, msg := range batch {
+				if name, ok := storage.ObjectName(msg.MediaURL); ok {
+					refs[name] = append(refs[name], mediaRef{
+						ID:        msg.ID,
+						ChatID:    msg.ChatID,
+						AccountID: msg.AccountID,
+						MessageID: msg.MessageID,
+					})
+				}
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load media URLs: %w", err)
+	}
+
+	report := &Report{}
+	seen := make(map[string]bool, len(refs))
+	var broken []mediaRef
+	err = st.Walk(ctx, func(objectName string, info storage.ObjectInfo) error {
+		report.Checked++
+		messages, referenced := refs[objectName]
+		if !referenced {
+			report.Orphaned = append(report.Orphaned, Problem{ObjectName: objectName, Reason: "not referenced by any message"})
+			if opts.DeleteOrphans && time.Since(info.LastModified) > opts.OrphanGrace {
+				if err := st.Delete(ctx, objectName); err != nil {
+					return fmt.Errorf("delete orphan %s: %w", objectName, err)
+				}
+				report.Deleted++
+			}
+			return nil
+		}
+		seen[objectName] = true
+
+		reason, err := checkObject(ctx, st, objectName, info, opts.Checksums)
+		if err != nil {
+			return err
+		}
+		if reason != "" {
+			for _, ref := range messages {
+				report.Corrupt = append(report.Corrupt, Problem{ObjectName: objectName, Reason: reason, MessageID: &ref.ID})
+			}
+			broken = append(broken, messages...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify storage: %w", err)
+	}
+
+	for objectName, messages := range refs {
+		if seen[objectName] {
+			continue
+		}
+		// Walk may skip objects the backend still finds, such as media
+		// stored at the bucket root before base_path was honoured.
+		info, err := st.Stat(ctx, objectName)
+		if err == nil {
+			report.Checked++
+			reason, err := checkObject(ctx, st, objectName, info, opts.Checksums)
+			if err != nil {
+				return nil, fmt.Errorf("failed to verify storage: %w", err)
+			}
+			if reason == "" {
+				continue
+			}
+			for _, ref := range messages {
+				report.Corrupt = append(report.Corrupt, Problem{ObjectName: objectName, Reason: reason, MessageID: &ref.ID})
+			}
+			broken = append(broken, messages...)
+			continue
+		}
+		if !errors.Is(err, storage.ErrObjectNotFound) {
+			return nil, fmt.Errorf("failed to verify storage: %w", err)
+		}
+		for _, ref := range messages {
+			report.Missing = append(report.Missing, Problem{ObjectName: objectName, Reason: "object not found", MessageID: &ref.ID})
+		}
+		broken = append(broken, messages...)
+	}
+
+	if opts.RequeueMissing {
+		if err := requeue(database, broken, report); err != nil {
+			return nil, err
+		}
+	} else {
+		for _, ref := range broken {
+			if ref.AccountID == nil {
+				report.Unrequeued++
+			}
+		}
+	}
+
+	metrics.VerifyObjects.WithLabelValues("missing").Set(float64(len(report.Missing)))
+	metrics.VerifyObjects.WithLabelValues("orphaned").Set(float64(len(report.Orphaned)))
+	metrics.VerifyObjects.WithLabelValues("corrupt").Set(float64(len(report.Corrupt)))
+	metrics.VerifyObjects.WithLabelValues("checked").Set(float64(report.Checked))
+	metrics.LastVerify.SetToCurrentTime()
+
+	log.Info().
+		Int("checked", report.Checked).
+		Int("missing", len(report.Missing)).
+		Int("orphaned", len(report.Orphaned)).
+		Int("corrupt", len(report.Corrupt)).
+		Int("requeued", report.Requeued).
+		Int("deleted", report.Deleted).
+		Dur("duration", time.Since(started)).
+		Msg("Verified storage")
+	return report, nil
+}
+
+// checkObject returns why the object is corrupt, or "" if it looks fine.
+// Empty objects are always corrupt: Telegram never sends empty media.
+func checkObject(ctx context.Context, st storage.Storage, objectName string, info storage.ObjectInfo, checksums bool) (string, error) {
+	if info.Size == 0 {
+		return "empty object", nil
+	}
+	if !checksums {
+		// Listings carry no metadata to compare the size with.
+		info, err := st.Stat(ctx, objectName)
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			return "object not found", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		return sizeMismatch(info), nil
+	}
+
+	obj, info, err := st.Open(ctx, objectName)
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		return "object not found", nil
+	}
+	if err != nil {
+		// Decryption failures mean the object was damaged.
+		if ctx.Err() == nil {
+			return err.Error(), nil
+		}
+		return "", err
+	}
+	defer obj.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, obj); err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return fmt.Sprintf("read failed: %v", err), nil
+	}
+
+	// Open fills in the metadata where the backend keeps it.
+	if reason := sizeMismatch(info); reason != "" {
+		return reason, nil
+	}
+	if want := info.Metadata[storage.MetaSHA256]; want != "" && want != hex.EncodeToString(hash.Sum(nil)) {
+		return "checksum mismatch", nil
+	}
+	return "", nil
+}
+
+// sizeMismatch compares the size of an object with the one recorded in its
+// metadata. Objects stored by older releases, and by backends without
+// metadata, have none.
+func sizeMismatch(info storage.ObjectInfo) string {
+	want, err := strconv.ParseInt(info.Metadata[storage.MetaSize], 10, 64)
+	if err != nil || info.Size == want {
+		return ""
+	}
+	return fmt.Sprintf("size %d, expected %d", info.Size, want)
+}
+
+// requeue schedules the media of broken messages to be downloaded again,
+// grouped by chat and account.
+func requeue(database *db.DB, broken []mediaRef, report *Report) error {
+	type chatKey struct{ chat, account uuid.UUID }
+	byChat := make(map[chatKey][]int)
+	for _, ref := range broken {
+		if ref.AccountID == nil {
+			report.Unrequeued++
+			continue
+		}
+		key := chatKey{ref.ChatID, *ref.AccountID}
+		byChat[key] = append(byChat[key], ref.MessageID)
+	}
+	for key, messageIDs := range byChat {
+		if err := database.RequeueMedia(key.chat, key.account, messageIDs); err != nil {
+			return err
+		}
+		report.Requeued += len(messageIDs)
+	}
+	return nil
+}
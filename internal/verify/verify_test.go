@@ -0,0 +1,177 @@
+package verify
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"tmd/internal/db"
+	"tmd/pkg/cfg"
+	"tmd/pkg/storage"
+)
+
+// metaStorage adds metadata to a local storage, which keeps none.
+type metaStorage struct {
+	storage.Storage
+	meta map[string]map[string]string
+}
+
+func (s metaStorage) Stat(ctx context.Context, objectName string) (storage.ObjectInfo, error) {
+	info, err := s.Storage.Stat(ctx, objectName)
+	info.Metadata = s.meta[objectName]
+	return info, err
+}
+
+func (s metaStorage) Open(ctx context.Context, objectName string) (io.ReadSeekCloser, storage.ObjectInfo, error) {
+	r, info, err := s.Storage.Open(ctx, objectName)
+	info.Metadata = s.meta[objectName]
+	return r, info, err
+}
+
+func newTestDB(t *testing.T) *db.DB {
+	t.Helper()
+	var c cfg.Config
+	c.Database.Dialect = "sqlite"
+	c.Database.Path = filepath.Join(t.TempDir(), "tmd.db")
+	c.Database.LogLevel = "silent"
+	database, err := db.NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = database.Shutdown() })
+	if _, err := database.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	return database
+}
+
+func objectNames(problems []Problem) []string {
+	names := []string{}
+	for _, p := range problems {
+		names = append(names, p.ObjectName)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	database := newTestDB(t)
+	local, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := metaStorage{Storage: local, meta: make(map[string]map[string]string)}
+
+	account, err := database.EnsureAccount("me")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chat := &db.Chat{TelegramID: 100, PeerType: "chat", Title: "Group"}
+	user := &db.User{TelegramUserID: 200}
+	if err := database.Conn.Create(chat).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := database.Conn.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(objectName, content string, meta map[string]string) {
+		t.Helper()
+		if _, err := local.Put(ctx, objectName, strings.NewReader(content), int64(len(content)), storage.PutOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		st.meta[objectName] = meta
+	}
+	checksum := func(content string) string {
+		sum := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(sum[:])
+	}
+	addMessage := func(messageID int, accountID *uuid.UUID, objectName string) {
+		t.Helper()
+		msg := &db.Message{
+			MessageID:   messageID,
+			ChatID:      chat.ID,
+			UserID:      user.ID,
+			AccountID:   accountID,
+			MessageType: "photo",
+			MediaURL:    "file://" + objectName,
+		}
+		if err := database.Conn.Create(msg).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	put("chat/1.jpg", "fine", map[string]string{storage.MetaSHA256: checksum("fine"), storage.MetaSize: "4"})
+	addMessage(1, &account.ID, "chat/1.jpg")
+	addMessage(2, &account.ID, "chat/2.jpg")
+	put("chat/3.jpg", "orphan", nil)
+	put("chat/4.jpg", "", nil)
+	addMessage(4, &account.ID, "chat/4.jpg")
+	// Archived before accounts existed, so it can't be downloaded again.
+	addMessage(5, nil, "chat/5.jpg")
+	put("chat/6.jpg", "damaged", map[string]string{storage.MetaSHA256: checksum("original")})
+	addMessage(6, &account.ID, "chat/6.jpg")
+	put("chat/7.jpg", "truncated", map[string]string{storage.MetaSize: "100"})
+	addMessage(7, &account.ID, "chat/7.jpg")
+	// Stored without metadata by an older release.
+	put("chat/8.jpg", "old", nil)
+	addMessage(8, &account.ID, "chat/8.jpg")
+
+	report, err := Run(ctx, database, st, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 6 || report.Problems() != 5 || report.Unrequeued != 1 || report.Requeued != 0 || report.Deleted != 0 {
+		t.Errorf("report = %+v", report)
+	}
+	if got := objectNames(report.Missing); !reflect.DeepEqual(got, []string{"chat/2.jpg", "chat/5.jpg"}) {
+		t.Errorf("missing = %v", got)
+	}
+	if got := objectNames(report.Orphaned); !reflect.DeepEqual(got, []string{"chat/3.jpg"}) {
+		t.Errorf("orphaned = %v", got)
+	}
+	// Checksums are only compared with Options.Checksums.
+	if got := objectNames(report.Corrupt); !reflect.DeepEqual(got, []string{"chat/4.jpg", "chat/7.jpg"}) {
+		t.Errorf("corrupt = %v", got)
+	}
+
+	report, err = Run(ctx, database, st, Options{Checksums: true, RequeueMissing: true, DeleteOrphans: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := objectNames(report.Corrupt); !reflect.DeepEqual(got, []string{"chat/4.jpg", "chat/6.jpg", "chat/7.jpg"}) {
+		t.Errorf("corrupt with checksums = %v", got)
+	}
+	for _, p := range report.Corrupt {
+		if p.MessageID == nil {
+			t.Errorf("corrupt object %s has no message", p.ObjectName)
+		}
+	}
+	if report.Requeued != 4 || report.Unrequeued != 1 || report.Deleted != 1 {
+		t.Errorf("report = %+v", report)
+	}
+	if _, err := local.Stat(ctx, "chat/3.jpg"); err == nil {
+		t.Error("orphan was not deleted")
+	}
+
+	var requeued []int
+	if err := database.Conn.Model(&db.Message{}).Where("media_url = ''").Order("message_id").
+		Pluck("message_id", &requeued).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(requeued, []int{2, 4, 6, 7}) {
+		t.Errorf("requeued messages = %v", requeued)
+	}
+	missing, err := database.MissingMedia(account.ID)
+	if err != nil || !reflect.DeepEqual(missing[chat.ID], []int{2, 4, 6, 7}) {
+		t.Errorf("missing media = %v, %v", missing, err)
+	}
+}
@@ -0,0 +1,239 @@
+package web
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"tmd/internal/db"
+)
+
+const (
+	anonymousActor = "anonymous"
+	actorKey       = "audit_actor"
+)
+
+// auditActions names the action of each route. Keys prefixed with a method
+// take precedence, for paths serving more than one action.
+var auditActions = map[string]string{
+	"/api/v1/chats":                  "chats.list",
+	"/api/v1/accounts":               "accounts.list",
+	"/api/v1/sync":                   "sync.status",
+	"POST /api/v1/sync":              "sync.all",
+	"/api/v1/sync/jobs":              "sync.jobs",
+	"/api/v1/sync/jobs/:jobID":       "sync.job",
+	"/api/v1/chats/:chatID/sync":     "sync.chat",
+	"/api/v1/chats/:chatID/messages": "messages.view",
+	"/api/v1/files/*objectName":      "file.access",
+	"/api/v1/search":                 "messages.search",
+	"/api/v1/admin/audit":            "audit.query",
+	"/api/v1/admin/login":            "login.status",
+	"/api/v1/admin/login/qr.png":     "login.qr",
+	"/api/v1/admin/login/phone":      "login.phone",
+	"/api/v1/admin/login/code":       "login.code",
+	"/api/v1/admin/login/password":   "login.password",
+}
+
+type AuditEventResponse struct {
+	ID        string `json:"id"`
+	Actor     string `json:"actor"`
+	Action    string `json:"action"`
+	Resource  string `json:"resource"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+	Status    int    `json:"status"`
+	CreatedAt string `json:"created_at"`
+}
+
+// AuditMiddleware records who accessed which resource once the request has
+// been handled, so the stored status reflects the actual outcome. Identity
+// headers are only taken from the given trusted proxies.
+func AuditMiddleware(database *db.DB, trustedProxies []string) gin.HandlerFunc {
+	proxies := parseProxies(trustedProxies)
+	return func(c *gin.Context) {
+		if actor := forwardedActor(c, proxies); actor != "" {
+			c.Set(actorKey, actor)
+		}
+		c.Next()
+
+		action, ok := auditActions[c.Request.Method+" "+c.FullPath()]
+		if !ok {
+			action, ok = auditActions[c.FullPath()]
+		}
+		if !ok {
+			action = strings.ToLower(c.Request.Method) + " " + c.FullPath()
+		}
+
+		event := db.AuditEvent{
+			Actor:     requestActor(c),
+			Action:    action,
+			Resource:  c.Request.URL.RequestURI(),
+			IP:        c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+			Status:    c.Writer.Status(),
+		}
+		if err := database.Conn.Create(&event).Error; err != nil {
+			log.Error().
+				Err(err).
+				Str("action", event.Action).
+				Str("resource", event.Resource).
+				Msg("Failed to write audit event")
+		}
+	}
+}
+
+// AdminMiddleware guards admin endpoints with a static bearer token. The admin
+// API is disabled entirely when no token is configured.
+func AdminMiddleware(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin API is disabled"})
+			return
+		}
+		provided, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		c.Set(actorKey, "admin")
+		c.Next()
+	}
+}
+
+// requestActor identifies the caller, or returns anonymousActor when neither
+// the admin token nor a trusted proxy identified it.
+func requestActor(c *gin.Context) string {
+	if actor := c.GetString(actorKey); actor != "" {
+		return actor
+	}
+	return anonymousActor
+}
+
+// forwardedActor returns the identity forwarded by an authenticating reverse
+// proxy, since there are no built-in user accounts. Anyone could set these
+// headers, so requests from other peers stay anonymous and are identified by
+// their IP alone.
+func forwardedActor(c *gin.Context, proxies []*net.IPNet) string {
+	if !isTrustedProxy(c.RemoteIP(), proxies) {
+		return ""
+	}
+	for _, header := range []string{"X-Remote-User", "X-Forwarded-User", "X-Forwarded-Email"} {
+		if v := strings.TrimSpace(c.GetHeader(header)); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// parseProxies turns web.trusted_proxies, given as IPs or CIDRs, into
+// networks. The router has already rejected invalid entries.
+func parseProxies(trustedProxies []string) []*net.IPNet {
+	proxies := make([]*net.IPNet, 0, len(trustedProxies))
+	for _, proxy := range trustedProxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			proxies = append(proxies, network)
+		}
+	}
+	return proxies
+}
+
+func isTrustedProxy(remoteIP string, proxies []*net.IPNet) bool {
+	ip := net.ParseIP(remoteIP)
+	if ip == nil {
+		return false
+	}
+	for _, network := range proxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) GetAuditEvents(ctx *gin.Context) {
+	page, perPage, ok := h.pagination(ctx)
+	if !ok {
+		return
+	}
+
+	query := h.DB.Conn.Model(&db.AuditEvent{})
+	if actor := ctx.Query("actor"); actor != "" {
+		query = query.Where("actor = ?", actor)
+	}
+	if action := ctx.Query("action"); action != "" {
+		query = query.Where("action = ?", action)
+	}
+	if ip := ctx.Query("ip"); ip != "" {
+		query = query.Where("ip = ?", ip)
+	}
+	if resource := ctx.Query("resource"); resource != "" {
+		query = query.Where("resource LIKE ?", "%"+resource+"%")
+	}
+	if since := ctx.Query("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since timestamp"})
+			return
+		}
+		query = query.Where("created_at >= ?", t)
+	}
+	if until := ctx.Query("until"); until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid until timestamp"})
+			return
+		}
+		query = query.Where("created_at < ?", t)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	var events []db.AuditEvent
+	if err := query.
+		Order("created_at DESC").
+		Limit(perPage).
+		Offset((page - 1) * perPage).
+		Find(&events).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	response := make([]AuditEventResponse, len(events))
+	for i, e := range events {
+		response[i] = AuditEventResponse{
+			ID:        e.ID.String(),
+			Actor:     e.Actor,
+			Action:    e.Action,
+			Resource:  e.Resource,
+			IP:        e.IP,
+			UserAgent: e.UserAgent,
+			Status:    e.Status,
+			CreatedAt: e.CreatedAt.Format(time.RFC3339),
+		}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"data": response,
+		"meta": gin.H{
+			"page":       page,
+			"per_page":   perPage,
+			"total":      total,
+			"totalPages": (int(total) + perPage - 1) / perPage,
+		},
+	})
+}
@@ -0,0 +1,176 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"tmd/internal/db"
+	"tmd/pkg/cfg"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newTestDB returns a migrated SQLite database in a temporary directory.
+func newTestDB(t *testing.T) *db.DB {
+	t.Helper()
+	var c cfg.Config
+	c.Database.Dialect = "sqlite"
+	c.Database.Path = filepath.Join(t.TempDir(), "tmd.db")
+	c.Database.LogLevel = "silent"
+	database, err := db.NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = database.Shutdown() })
+	if _, err := database.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	return database
+}
+
+func TestAdminMiddleware(t *testing.T) {
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		want          int
+	}{
+		{"disabled", "", "Bearer ", http.StatusForbidden},
+		{"disabled with a token", "", "Bearer secret", http.StatusForbidden},
+		{"missing", "secret", "", http.StatusUnauthorized},
+		{"wrong", "secret", "Bearer wrong", http.StatusUnauthorized},
+		{"prefix of the token", "secret", "Bearer secre", http.StatusUnauthorized},
+		{"without scheme", "secret", "secret", http.StatusUnauthorized},
+		{"other scheme", "secret", "Basic secret", http.StatusUnauthorized},
+		{"correct", "secret", "Bearer secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.GET("/admin", AdminMiddleware(tt.token), func(c *gin.Context) {
+				c.String(http.StatusOK, requestActor(c))
+			})
+			req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			if w.Code == http.StatusOK && w.Body.String() != "admin" {
+				t.Errorf("actor = %q", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestAuditMiddleware(t *testing.T) {
+	database := newTestDB(t)
+	proxies := []string{"10.0.0.0/8", "::1"}
+	r := gin.New()
+	if err := r.SetTrustedProxies(proxies); err != nil {
+		t.Fatal(err)
+	}
+	api := r.Group("/api/v1", AuditMiddleware(database, proxies))
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	api.GET("/chats", ok)
+	api.GET("/sync", ok)
+	api.POST("/sync", ok)
+	api.GET("/admin/audit", AdminMiddleware("secret"), ok)
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		remoteAddr string
+		headers    map[string]string
+		actor      string
+		action     string
+		status     int
+	}{
+		{
+			name: "anonymous", method: http.MethodGet, target: "/api/v1/chats?page=2", remoteAddr: "192.0.2.1:1234",
+			actor: anonymousActor, action: "chats.list", status: http.StatusOK,
+		},
+		{
+			name: "forwarded by an untrusted peer", method: http.MethodGet, target: "/api/v1/chats", remoteAddr: "192.0.2.1:1234",
+			headers: map[string]string{"X-Remote-User": "alice"},
+			actor:   anonymousActor, action: "chats.list", status: http.StatusOK,
+		},
+		{
+			name: "forwarded by a trusted proxy", method: http.MethodGet, target: "/api/v1/chats", remoteAddr: "10.1.2.3:1234",
+			headers: map[string]string{"X-Remote-User": " alice ", "X-Forwarded-Email": "bob@example.com"},
+			actor:   "alice", action: "chats.list", status: http.StatusOK,
+		},
+		{
+			name: "email from a trusted IPv6 proxy", method: http.MethodGet, target: "/api/v1/chats", remoteAddr: "[::1]:1234",
+			headers: map[string]string{"X-Forwarded-Email": "bob@example.com"},
+			actor:   "bob@example.com", action: "chats.list", status: http.StatusOK,
+		},
+		{
+			name: "trusted proxy without identity", method: http.MethodGet, target: "/api/v1/chats", remoteAddr: "10.1.2.3:1234",
+			actor: anonymousActor, action: "chats.list", status: http.StatusOK,
+		},
+		{
+			name: "action by method", method: http.MethodPost, target: "/api/v1/sync", remoteAddr: "192.0.2.1:1234",
+			actor: anonymousActor, action: "sync.all", status: http.StatusOK,
+		},
+		{
+			name: "admin", method: http.MethodGet, target: "/api/v1/admin/audit", remoteAddr: "10.1.2.3:1234",
+			headers: map[string]string{"Authorization": "Bearer secret", "X-Remote-User": "alice"},
+			actor:   "admin", action: "audit.query", status: http.StatusOK,
+		},
+		{
+			name: "rejected admin", method: http.MethodGet, target: "/api/v1/admin/audit", remoteAddr: "192.0.2.1:1234",
+			headers: map[string]string{"Authorization": "Bearer wrong", "X-Remote-User": "alice"},
+			actor:   anonymousActor, action: "audit.query", status: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("User-Agent", "test")
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			r.ServeHTTP(httptest.NewRecorder(), req)
+
+			var event db.AuditEvent
+			if err := database.Conn.Order("created_at DESC").First(&event).Error; err != nil {
+				t.Fatal(err)
+			}
+			if event.Actor != tt.actor || event.Action != tt.action || event.Status != tt.status ||
+				event.Resource != tt.target || event.UserAgent != "test" {
+				t.Errorf("audit event = %+v", event)
+			}
+		})
+	}
+}
+
+func TestParseProxies(t *testing.T) {
+	proxies := parseProxies([]string{"10.0.0.0/8", "192.0.2.7", "2001:db8::1", "not-an-ip"})
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.255.0.1", true},
+		{"192.0.2.7", true},
+		{"192.0.2.8", false},
+		{"2001:db8::1", true},
+		{"2001:db8::2", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isTrustedProxy(tt.ip, proxies); got != tt.want {
+			t.Errorf("isTrustedProxy(%q) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
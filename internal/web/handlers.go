@@ -1,8 +1,11 @@
 package web
 
 import (
+	"errors"
 	"github.com/google/uuid"
+	"mime"
 	"net/http"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -10,40 +13,114 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"tmd/internal/db"
-	"tmd/pkg/minio"
+	"tmd/internal/health"
+	"tmd/internal/login"
+	"tmd/pkg/cfg"
+	"tmd/pkg/storage"
 )
 
 type Handler struct {
-	DB        *db.DB
-	Minio     *minio.Storage
-	PageLimit int
+	DB           *db.DB
+	Storage      storage.Storage
+	PageLimit    int
+	MaxPageLimit int
+	ProxyMedia   bool
+	Login        *login.Broker
+	Health       *health.Tracker
+	// Accounts maps the names of the configured accounts to whether they are
+	// bots, which archive from updates and never run sync jobs.
+	Accounts map[string]bool
 }
 
-func NewHandler(db *db.DB, minio *minio.Storage) *Handler {
+func NewHandler(db *db.DB, st storage.Storage, broker *login.Broker, tracker *health.Tracker, config *cfg.Config) *Handler {
+	accounts := make(map[string]bool, len(config.Telegram.Accounts))
+	for _, account := range config.Telegram.Accounts {
+		accounts[account.Name] = account.IsBot()
+	}
 	return &Handler{
-		DB:        db,
-		Minio:     minio,
-		PageLimit: 50,
+		DB:           db,
+		Storage:      st,
+		Login:        broker,
+		Health:       tracker,
+		PageLimit:    config.Web.PageSize,
+		MaxPageLimit: config.Web.MaxPageSize,
+		ProxyMedia:   config.Web.MediaMode == "proxy",
+		Accounts:     accounts,
 	}
 }
 
 type MessageResponse struct {
 	ID        string `json:"id"`
+	ChatID    string `json:"chat_id"`
+	AccountID string `json:"account_id,omitempty"`
 	Content   string `json:"content"`
 	MediaURL  string `json:"media_url"`
 	CreatedAt string `json:"created_at"`
 	Username  string `json:"username"`
 }
 type ChatResponse struct {
-	ID        string `json:"id"`
-	Title     string `json:"title"`
-	CreatedAt string `json:"created_at"`
+	ID        string               `json:"id"`
+	Title     string               `json:"title"`
+	PeerType  string               `json:"peer_type"`
+	AccountID string               `json:"account_id,omitempty"`
+	CreatedAt string               `json:"created_at"`
+	Sync      []SyncStatusResponse `json:"sync"`
+}
+type AccountResponse struct {
+	ID             string `json:"id"`
+	Name           string `json:"name"`
+	Username       string `json:"username"`
+	TelegramUserID int64  `json:"telegram_user_id"`
+}
+
+func (h *Handler) GetAccounts(c *gin.Context) {
+	var accounts []db.Account
+	if err := h.DB.Conn.Order("name").Find(&accounts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	response := make([]AccountResponse, len(accounts))
+	for i, account := range accounts {
+		response[i] = AccountResponse{
+			ID:             account.ID.String(),
+			Name:           account.Name,
+			Username:       account.Username,
+			TelegramUserID: account.TelegramUserID,
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"data": response})
 }
 
 func (h *Handler) GetChats(c *gin.Context) {
+	query := h.DB.Conn.Order("created_at DESC")
+	var accountFilter *uuid.UUID
+	if raw := c.Query("account_id"); raw != "" {
+		accountID, err := uuid.Parse(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account id"})
+			return
+		}
+		accountFilter = &accountID
+		query = query.Where(
+			"account_id = ? OR id IN (?)",
+			accountID,
+			h.DB.Conn.Model(&db.Message{}).Select("chat_id").Where("account_id = ?", accountID),
+		)
+	}
+
 	var chats []db.Chat
-	result := h.DB.Conn.Order("created_at DESC").Find(&chats)
-	if result.Error != nil {
+	if err := query.Find(&chats).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	chatIDs := make([]uuid.UUID, len(chats))
+	for i, chat := range chats {
+		chatIDs[i] = chat.ID
+	}
+	syncStatus, err := h.chatSyncStatus(chatIDs, accountFilter)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
@@ -53,7 +130,13 @@ func (h *Handler) GetChats(c *gin.Context) {
 		response[i] = ChatResponse{
 			ID:        chat.ID.String(),
 			Title:     chat.Title,
+			PeerType:  chat.PeerType,
+			AccountID: uuidString(chat.AccountID),
 			CreatedAt: chat.CreatedAt.Format(time.RFC3339),
+			Sync:      syncStatus[chat.ID],
+		}
+		if response[i].Sync == nil {
+			response[i].Sync = []SyncStatusResponse{}
 		}
 	}
 	c.JSON(http.StatusOK, gin.H{"data": response})
@@ -66,14 +149,13 @@ func (h *Handler) GetChatMessages(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat id"})
 		return
 	}
-	page, err := strconv.Atoi(ctx.DefaultQuery("page", "1"))
-	if err != nil || page < 1 {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page number"})
+	page, perPage, ok := h.pagination(ctx)
+	if !ok {
 		return
 	}
 
 	mediaType := ctx.Query("media_type")
-	offset := (page - 1) * h.PageLimit
+	offset := (page - 1) * perPage
 
 	var messages []db.Message
 	var total int64
@@ -84,6 +166,17 @@ func (h *Handler) GetChatMessages(ctx *gin.Context) {
 		query = query.Where("message_type = ?", mediaType)
 	}
 
+	if raw := ctx.Query("account_id"); raw != "" {
+		accountID, err := uuid.Parse(raw)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account id"})
+			return
+		}
+		query = query.Where("account_id = ?", accountID)
+	} else {
+		query = query.Scopes(db.DistinctMessages)
+	}
+
 	if err := query.Count(&total).Error; err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
@@ -92,47 +185,41 @@ func (h *Handler) GetChatMessages(ctx *gin.Context) {
 	if err := query.
 		Preload("User").
 		Order("created_at DESC").
-		Limit(h.PageLimit).
+		Limit(perPage).
 		Offset(offset).
 		Find(&messages).Error; err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
 
-	response := make([]MessageResponse, len(messages))
-	for i, msg := range messages {
-		username := ""
-		if msg.User != nil {
-			username = msg.User.Username
-		}
-		response[i] = MessageResponse{
-			ID:        msg.ID.String(),
-			Content:   msg.Content,
-			MediaURL:  msg.MediaURL,
-			CreatedAt: msg.CreatedAt.Format(time.RFC3339),
-			Username:  username,
-		}
-	}
-
 	ctx.JSON(http.StatusOK, gin.H{
-		"data": response,
+		"data": messageResponses(messages),
 		"meta": gin.H{
 			"page":       page,
-			"per_page":   h.PageLimit,
+			"per_page":   perPage,
 			"total":      total,
-			"totalPages": (int(total) + h.PageLimit - 1) / h.PageLimit,
+			"totalPages": (int(total) + perPage - 1) / perPage,
 		},
 	})
 }
 
 func (h *Handler) GetFile(c *gin.Context) {
-	objectName := c.Param("objectName")
+	objectName := strings.TrimPrefix(c.Param("objectName"), "/")
 	if !isValidObjectName(objectName) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file path"})
 		return
 	}
 
-	presignedURL, err := h.Minio.GeneratePresignedURL(objectName, 5*time.Minute)
+	if h.ProxyMedia {
+		h.streamFile(c, objectName)
+		return
+	}
+
+	presignedURL, err := h.Storage.PresignedURL(c.Request.Context(), objectName, 5*time.Minute)
+	if errors.Is(err, storage.ErrPresignUnsupported) {
+		h.streamFile(c, objectName)
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate URL"})
 		return
@@ -141,6 +228,85 @@ func (h *Handler) GetFile(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"url": presignedURL})
 }
 
+// pagination parses the page and per_page query parameters, writing a 400
+// response and returning false when either is invalid.
+func (h *Handler) pagination(ctx *gin.Context) (int, int, bool) {
+	page, err := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page number"})
+		return 0, 0, false
+	}
+
+	perPage := h.PageLimit
+	if raw := ctx.Query("per_page"); raw != "" {
+		perPage, err = strconv.Atoi(raw)
+		if err != nil || perPage < 1 || perPage > h.MaxPageLimit {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid per_page value"})
+			return 0, 0, false
+		}
+	}
+	return page, perPage, true
+}
+
+// streamFile serves the object through the API. http.ServeContent takes care
+// of Range, If-Range and If-None-Match handling based on the ETag set here.
+func (h *Handler) streamFile(c *gin.Context, objectName string) {
+	obj, info, err := h.Storage.Open(c.Request.Context(), objectName)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open file"})
+		return
+	}
+	defer obj.Close()
+
+	disposition := "inline"
+	if c.Query("download") != "" {
+		disposition = "attachment"
+	}
+	fileName := path.Base(objectName)
+
+	if info.ETag != "" {
+		c.Header("ETag", strconv.Quote(info.ETag))
+	}
+	if info.ContentType != "" {
+		c.Header("Content-Type", info.ContentType)
+	}
+	c.Header("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": fileName}))
+	c.Header("Cache-Control", "private, max-age=86400")
+
+	http.ServeContent(c.Writer, c.Request, fileName, info.LastModified, obj)
+}
+
+func messageResponses(messages []db.Message) []MessageResponse {
+	response := make([]MessageResponse, len(messages))
+	for i, msg := range messages {
+		username := ""
+		if msg.User != nil {
+			username = msg.User.Username
+		}
+		response[i] = MessageResponse{
+			ID:        msg.ID.String(),
+			ChatID:    msg.ChatID.String(),
+			AccountID: uuidString(msg.AccountID),
+			Content:   msg.Content,
+			MediaURL:  msg.MediaURL,
+			CreatedAt: msg.CreatedAt.Format(time.RFC3339),
+			Username:  username,
+		}
+	}
+	return response
+}
+
+func uuidString(id *uuid.UUID) string {
+	if id == nil {
+		return ""
+	}
+	return id.String()
+}
+
 func isValidObjectName(name string) bool {
-	return !filepath.IsAbs(name) && !strings.Contains(name, "..")
+	return name != "" && !filepath.IsAbs(name) && !strings.Contains(name, "..")
 }
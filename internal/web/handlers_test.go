@@ -0,0 +1,125 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"tmd/pkg/envelope"
+	"tmd/pkg/storage"
+)
+
+func TestStreamFileRanges(t *testing.T) {
+	// Spans several chunks of the encrypted format.
+	content := make([]byte, 200_000)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	size := len(content)
+
+	local, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := envelope.LoadMasterKey("secret", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backends := map[string]storage.Storage{
+		"plain":     local,
+		"encrypted": storage.NewEncrypted(local, key, true),
+	}
+
+	tests := []struct {
+		name         string
+		rangeHeader  string
+		status       int
+		start, end   int
+		contentRange string
+	}{
+		{"whole file", "", http.StatusOK, 0, size, ""},
+		{"single range", "bytes=0-9", http.StatusPartialContent, 0, 10, fmt.Sprintf("bytes 0-9/%d", size)},
+		{"across chunks", "bytes=65530-65545", http.StatusPartialContent, 65530, 65546, fmt.Sprintf("bytes 65530-65545/%d", size)},
+		{"open-ended", "bytes=199990-", http.StatusPartialContent, 199990, size, fmt.Sprintf("bytes 199990-199999/%d", size)},
+		{"suffix", "bytes=-100", http.StatusPartialContent, size - 100, size, fmt.Sprintf("bytes %d-%d/%d", size-100, size-1, size)},
+		{"end past the file", "bytes=199000-500000", http.StatusPartialContent, 199000, size, fmt.Sprintf("bytes 199000-199999/%d", size)},
+		{"unsatisfiable", "bytes=300000-", http.StatusRequestedRangeNotSatisfiable, 0, 0, fmt.Sprintf("bytes */%d", size)},
+	}
+	for name, st := range backends {
+		t.Run(name, func(t *testing.T) {
+			objectName := name + "/photo/1.jpg"
+			if _, err := st.Put(context.Background(), objectName, bytes.NewReader(content), int64(size), storage.PutOptions{ContentType: "image/jpeg"}); err != nil {
+				t.Fatal(err)
+			}
+			handler := &Handler{Storage: st, ProxyMedia: true}
+			r := gin.New()
+			r.GET("/api/v1/files/*objectName", handler.GetFile)
+
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					req := httptest.NewRequest(http.MethodGet, "/api/v1/files/"+objectName, nil)
+					if tt.rangeHeader != "" {
+						req.Header.Set("Range", tt.rangeHeader)
+					}
+					w := httptest.NewRecorder()
+					r.ServeHTTP(w, req)
+
+					if w.Code != tt.status {
+						t.Fatalf("status = %d, want %d", w.Code, tt.status)
+					}
+					if got := w.Header().Get("Content-Range"); got != tt.contentRange {
+						t.Errorf("Content-Range = %q, want %q", got, tt.contentRange)
+					}
+					if tt.status == http.StatusRequestedRangeNotSatisfiable {
+						return
+					}
+					if !bytes.Equal(w.Body.Bytes(), content[tt.start:tt.end]) {
+						t.Errorf("body has %d bytes, want bytes %d-%d", w.Body.Len(), tt.start, tt.end-1)
+					}
+					if got := w.Header().Get("Accept-Ranges"); got != "bytes" {
+						t.Errorf("Accept-Ranges = %q", got)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestStreamFileErrors(t *testing.T) {
+	local, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := local.Put(context.Background(), "chat/1.jpg", bytes.NewReader([]byte("data")), 4, storage.PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	handler := &Handler{Storage: local, ProxyMedia: true}
+	r := gin.New()
+	r.GET("/api/v1/files/*objectName", handler.GetFile)
+
+	tests := []struct {
+		target      string
+		status      int
+		disposition string
+	}{
+		{"/api/v1/files/chat/1.jpg", http.StatusOK, `inline; filename=1.jpg`},
+		{"/api/v1/files/chat/1.jpg?download=1", http.StatusOK, `attachment; filename=1.jpg`},
+		{"/api/v1/files/chat/2.jpg", http.StatusNotFound, ""},
+		{"/api/v1/files/chat/../../etc/passwd", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.target, w.Code, tt.status)
+		}
+		if got := w.Header().Get("Content-Disposition"); got != tt.disposition {
+			t.Errorf("%s: Content-Disposition = %q, want %q", tt.target, got, tt.disposition)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+	"tmd/internal/health"
+)
+
+const readinessTimeout = 5 * time.Second
+
+// GetHealth reports that the API is up together with the Telegram connection
+// state of every account syncing in this process. It answers 200 while the
+// client reconnects so the web server isn't restarted over a network blip.
+func (h *Handler) GetHealth(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "ok",
+		"accounts": h.Health.Accounts(),
+	})
+}
+
+// GetReady checks the database, the media storage and, when this process syncs, that
+// every account is connected to Telegram. It answers 503 if any check fails.
+// Failures are only logged; the response names the failed checks without
+// their errors, which may reveal hosts or credentials.
+func (h *Handler) GetReady(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := gin.H{
+		"database": checkResult("database", h.DB.Ping(ctx)),
+		"storage":  checkResult("storage", h.Storage.Check(ctx)),
+	}
+	ready := checks["database"] == "ok" && checks["storage"] == "ok"
+
+	accounts := h.Health.Accounts()
+	if len(accounts) > 0 {
+		var err error
+		for _, account := range accounts {
+			if account.State != health.StateConnected {
+				err = fmt.Errorf("account %q is %s", account.Account, account.State)
+				break
+			}
+		}
+		checks["telegram"] = checkResult("telegram", err)
+		ready = ready && err == nil
+	}
+
+	status, code := "ok", http.StatusOK
+	if !ready {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+	c.JSON(code, gin.H{"status": status, "checks": checks})
+}
+
+func checkResult(check string, err error) string {
+	if err != nil {
+		log.Warn().Err(err).Str("check", check).Msg("Readiness check failed")
+		return "failed"
+	}
+	return "ok"
+}
+
+// registerProbes adds the health, readiness and metrics endpoints.
+func registerProbes(r gin.IRoutes, handler *Handler) {
+	r.GET("/healthz", handler.GetHealth)
+	r.GET("/readyz", handler.GetReady)
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+}
+
+// SetupMetricsRouter serves only the probes, for `tmd sync`, which has no
+// API.
+func SetupMetricsRouter(handler *Handler) *gin.Engine {
+	r := gin.New()
+	r.Use(gin.Recovery())
+	registerProbes(r, handler)
+	return r
+}
@@ -0,0 +1,95 @@
+package web
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"tmd/internal/login"
+	"tmd/pkg/cfg"
+)
+
+//go:embed login.html
+var loginPage []byte
+
+type loginHandler struct {
+	broker *login.Broker
+}
+
+type loginInput struct {
+	Value string `json:"value" binding:"required"`
+}
+
+// registerLoginRoutes exposes the web login flow. The page itself is public,
+// every call it makes goes through the admin group.
+func registerLoginRoutes(r gin.IRoutes, admin *gin.RouterGroup, broker *login.Broker) {
+	h := &loginHandler{broker: broker}
+
+	r.GET("/login", h.page)
+	admin.GET("/login", h.status)
+	admin.GET("/login/qr.png", h.qrCode)
+	admin.POST("/login/phone", h.submit(login.StateAwaitingPhone))
+	admin.POST("/login/code", h.submit(login.StateAwaitingCode))
+	admin.POST("/login/password", h.submit(login.StateAwaitingPassword))
+}
+
+// SetupLoginRouter serves only the login flow, for processes that talk to
+// Telegram without serving the rest of the API.
+func SetupLoginRouter(broker *login.Broker, config *cfg.Config) (*gin.Engine, error) {
+	limit, err := rateLimitMiddleware(config.Web.RateLimit)
+	if err != nil {
+		return nil, fmt.Errorf("web.rate_limit: %w", err)
+	}
+	r := gin.New()
+	r.Use(gin.Recovery())
+	r.Use(limit, SecurityMiddleware())
+
+	admin := r.Group("/api/v1/admin")
+	admin.Use(AdminMiddleware(config.Web.AdminToken))
+	registerLoginRoutes(r, admin, broker)
+	return r, nil
+}
+
+func (h *loginHandler) page(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", loginPage)
+}
+
+func (h *loginHandler) status(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": h.broker.Status()})
+}
+
+func (h *loginHandler) qrCode(c *gin.Context) {
+	png, err := h.broker.QRPNG()
+	if err != nil {
+		if errors.Is(err, login.ErrNoQRCode) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No QR code available"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render QR code"})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "image/png", png)
+}
+
+func (h *loginHandler) submit(state login.State) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input loginInput
+		if err := c.ShouldBindJSON(&input); err != nil || strings.TrimSpace(input.Value) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Value is required"})
+			return
+		}
+		if err := h.broker.Submit(c.Request.Context(), state, strings.TrimSpace(input.Value)); err != nil {
+			if errors.Is(err, login.ErrNotAwaiting) {
+				c.JSON(http.StatusConflict, gin.H{"error": "Login is not waiting for this input"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to submit input"})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"data": h.broker.Status()})
+	}
+}
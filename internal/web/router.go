@@ -1,49 +1,114 @@
 package web
 
 import (
+	"fmt"
+
 	"github.com/gin-contrib/cors"
 	"github.com/gin-contrib/gzip"
 	"github.com/gin-gonic/gin"
 	"github.com/ulule/limiter/v3"
 	mgin "github.com/ulule/limiter/v3/drivers/middleware/gin"
 	"github.com/ulule/limiter/v3/drivers/store/memory"
-	"log"
+	"tmd/pkg/cfg"
 )
 
-func SetupRouter(handler *Handler) *gin.Engine {
+func SetupRouter(handler *Handler, config *cfg.Config) (*gin.Engine, error) {
 	r := gin.New()
 	r.Use(gin.Recovery())
 
-	rate, err := limiter.NewRateFromFormatted("100-M")
-	if err != nil {
-		log.Fatalf("Failed to create rate: %v", err)
+	if err := r.SetTrustedProxies(config.Web.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("web.trusted_proxies: %w", err)
 	}
 
-	store := memory.NewStore()
-	rateMiddleware := mgin.NewMiddleware(limiter.New(store, rate))
+	// Registered before the rate limiter so probes and scrapes are never
+	// throttled.
+	registerProbes(r, handler)
 
 	corsConfig := cors.DefaultConfig()
-	corsConfig.AllowOrigins = []string{"http://localhost:3003", "https://tmd-nanana.com"}
+	corsConfig.AllowOrigins = config.Web.CORSOrigins
 	corsConfig.AllowHeaders = append(corsConfig.AllowHeaders, "Authorization")
 	r.Use(
-		gzip.Gzip(gzip.DefaultCompression),
+		gzip.Gzip(gzip.DefaultCompression, gzip.WithExcludedPaths([]string{"/api/v1/files/"})),
 		cors.New(corsConfig),
-		rateMiddleware,
 		SecurityMiddleware(),
 	)
 
+	limits, err := newRouteLimiters(config)
+	if err != nil {
+		return nil, err
+	}
+	routeLimit := limits.route
+
 	api := r.Group("/api/v1")
+	api.Use(AuditMiddleware(handler.DB, config.Web.TrustedProxies))
 	{
-		api.GET("/chats/:chatID/messages", handler.GetChatMessages)
-		api.GET("/files/*objectName", handler.GetFile)
-		api.GET("/chats", handler.GetChats)
+		api.GET("/chats/:chatID/messages", routeLimit("messages"), handler.GetChatMessages)
+		api.GET("/search", routeLimit("messages"), handler.SearchMessages)
+		api.GET("/files/*objectName", routeLimit("files"), handler.GetFile)
+		api.GET("/chats", routeLimit("chats"), handler.GetChats)
+		api.GET("/accounts", routeLimit("chats"), handler.GetAccounts)
+		api.GET("/sync", routeLimit("chats"), handler.GetSyncStatus)
+		api.GET("/sync/jobs", routeLimit("chats"), handler.GetSyncJobs)
+		api.GET("/sync/jobs/:jobID", routeLimit("chats"), handler.GetSyncJob)
+		api.POST("/sync", routeLimit("sync"), handler.SyncAll)
+		api.POST("/chats/:chatID/sync", routeLimit("sync"), handler.SyncChat)
 	}
 
-	r.NoRoute(func(c *gin.Context) {
+	admin := api.Group("/admin")
+	admin.Use(routeLimit("admin"), AdminMiddleware(config.Web.AdminToken))
+	{
+		admin.GET("/audit", handler.GetAuditEvents)
+	}
+
+	if handler.Login != nil {
+		registerLoginRoutes(r.Group("", limits.fallback), admin, handler.Login)
+	}
+
+	r.NoRoute(limits.fallback, func(c *gin.Context) {
 		c.File("./frontend/public/index.html")
 	})
 
-	return r
+	return r, nil
+}
+
+// routeLimiters hands out one limiter per web.route_rate_limits key, so all
+// routes under a key share its budget. Routes without an override share the
+// default web.rate_limit instead of being limited by both.
+type routeLimiters struct {
+	fallback  gin.HandlerFunc
+	overrides map[string]gin.HandlerFunc
+}
+
+func newRouteLimiters(config *cfg.Config) (*routeLimiters, error) {
+	fallback, err := rateLimitMiddleware(config.Web.RateLimit)
+	if err != nil {
+		return nil, fmt.Errorf("web.rate_limit: %w", err)
+	}
+	limits := &routeLimiters{
+		fallback:  fallback,
+		overrides: make(map[string]gin.HandlerFunc, len(config.Web.RouteRateLimits)),
+	}
+	for route, formatted := range config.Web.RouteRateLimits {
+		if limits.overrides[route], err = rateLimitMiddleware(formatted); err != nil {
+			return nil, fmt.Errorf("web.route_rate_limits.%s: %w", route, err)
+		}
+	}
+	return limits, nil
+}
+
+func (l *routeLimiters) route(route string) gin.HandlerFunc {
+	if limit, ok := l.overrides[route]; ok {
+		return limit
+	}
+	return l.fallback
+}
+
+func rateLimitMiddleware(formatted string) (gin.HandlerFunc, error) {
+	rate, err := limiter.NewRateFromFormatted(formatted)
+	if err != nil {
+		return nil, err
+	}
+	return mgin.NewMiddleware(limiter.New(memory.NewStore(), rate)), nil
 }
 
 func SecurityMiddleware() gin.HandlerFunc {
@@ -0,0 +1,71 @@
+package web
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"tmd/internal/db"
+)
+
+// SearchMessages finds messages containing every word of the q parameter,
+// optionally within one chat (chat_id) or account (account_id), newest first.
+func (h *Handler) SearchMessages(c *gin.Context) {
+	text := strings.TrimSpace(c.Query("q"))
+	if text == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing search query"})
+		return
+	}
+	page, perPage, ok := h.pagination(c)
+	if !ok {
+		return
+	}
+
+	query := h.DB.Conn.Model(&db.Message{}).Scopes(h.DB.ContentMatches(text))
+	if raw := c.Query("chat_id"); raw != "" {
+		chatID, err := uuid.Parse(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat id"})
+			return
+		}
+		query = query.Where("chat_id = ?", chatID)
+	}
+	if raw := c.Query("account_id"); raw != "" {
+		accountID, err := uuid.Parse(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account id"})
+			return
+		}
+		query = query.Where("account_id = ?", accountID)
+	} else {
+		query = query.Scopes(db.DistinctMessages)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	var messages []db.Message
+	if err := query.
+		Preload("User").
+		Order("created_at DESC").
+		Limit(perPage).
+		Offset((page - 1) * perPage).
+		Find(&messages).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": messageResponses(messages),
+		"meta": gin.H{
+			"page":       page,
+			"per_page":   perPage,
+			"total":      total,
+			"totalPages": (int(total) + perPage - 1) / perPage,
+		},
+	})
+}
@@ -0,0 +1,389 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"tmd/internal/db"
+)
+
+type SyncStatusResponse struct {
+	ChatID           string  `json:"chat_id"`
+	ChatTitle        string  `json:"chat_title,omitempty"`
+	AccountID        string  `json:"account_id"`
+	LastSyncedID     int     `json:"last_synced_id"`
+	OldestArchivedID int     `json:"oldest_archived_id"`
+	BackfillComplete bool    `json:"backfill_complete"`
+	TotalMessages    int     `json:"total_messages"`
+	ArchivedMessages int     `json:"archived_messages"`
+	Progress         float64 `json:"progress"`
+	LastError        string  `json:"last_error,omitempty"`
+	LastRunAt        string  `json:"last_run_at,omitempty"`
+}
+
+func (h *Handler) GetSyncStatus(ctx *gin.Context) {
+	page, perPage, ok := h.pagination(ctx)
+	if !ok {
+		return
+	}
+
+	query := h.DB.Conn.Model(&db.SyncState{})
+	if raw := ctx.Query("account_id"); raw != "" {
+		accountID, err := uuid.Parse(raw)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account id"})
+			return
+		}
+		query = query.Where("account_id = ?", accountID)
+	}
+	if raw := ctx.Query("complete"); raw != "" {
+		complete, err := strconv.ParseBool(raw)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid complete value"})
+			return
+		}
+		query = query.Where("backfill_complete = ?", complete)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	var states []db.SyncState
+	if err := query.
+		Preload("Chat").
+		Order("last_run_at DESC NULLS LAST").
+		Limit(perPage).
+		Offset((page - 1) * perPage).
+		Find(&states).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	response := make([]SyncStatusResponse, len(states))
+	for i := range states {
+		response[i] = syncStatusResponse(&states[i])
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"data": response,
+		"meta": gin.H{
+			"page":       page,
+			"per_page":   perPage,
+			"total":      total,
+			"totalPages": (int(total) + perPage - 1) / perPage,
+		},
+	})
+}
+
+// chatSyncStatus loads the sync states of chats, keyed by chat ID.
+func (h *Handler) chatSyncStatus(chatIDs []uuid.UUID, accountID *uuid.UUID) (map[uuid.UUID][]SyncStatusResponse, error) {
+	byChat := make(map[uuid.UUID][]SyncStatusResponse, len(chatIDs))
+	if len(chatIDs) == 0 {
+		return byChat, nil
+	}
+
+	query := h.DB.Conn.Where("chat_id IN ?", chatIDs)
+	if accountID != nil {
+		query = query.Where("account_id = ?", *accountID)
+	}
+	var states []db.SyncState
+	if err := query.Find(&states).Error; err != nil {
+		return nil, err
+	}
+	for i := range states {
+		byChat[states[i].ChatID] = append(byChat[states[i].ChatID], syncStatusResponse(&states[i]))
+	}
+	return byChat, nil
+}
+
+func syncStatusResponse(state *db.SyncState) SyncStatusResponse {
+	response := SyncStatusResponse{
+		ChatID:           state.ChatID.String(),
+		AccountID:        state.AccountID.String(),
+		LastSyncedID:     state.LastSyncedID,
+		OldestArchivedID: state.OldestArchivedID,
+		BackfillComplete: state.BackfillComplete,
+		TotalMessages:    state.TotalMessages,
+		ArchivedMessages: state.ArchivedMessages,
+		Progress:         syncProgress(state),
+		LastError:        state.LastError,
+	}
+	if state.Chat != nil {
+		response.ChatTitle = state.Chat.Title
+	}
+	if state.LastRunAt != nil {
+		response.LastRunAt = state.LastRunAt.Format(time.RFC3339)
+	}
+	return response
+}
+
+// syncProgress estimates how much of the chat history is archived, in
+// percent. Telegram's message count includes messages that are skipped, so
+// the estimate is capped just below 100 until the backfill completes.
+func syncProgress(state *db.SyncState) float64 {
+	if state.BackfillComplete {
+		return 100
+	}
+	if state.TotalMessages <= 0 {
+		return 0
+	}
+	progress := float64(state.ArchivedMessages) * 100 / float64(state.TotalMessages)
+	return min(progress, 99.9)
+}
+
+type SyncJobResponse struct {
+	ID          string `json:"id"`
+	ChatID      string `json:"chat_id,omitempty"`
+	AccountID   string `json:"account_id,omitempty"`
+	Priority    int    `json:"priority"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+	RequestedBy string `json:"requested_by"`
+	CreatedAt   string `json:"created_at"`
+	StartedAt   string `json:"started_at,omitempty"`
+	FinishedAt  string `json:"finished_at,omitempty"`
+}
+
+// SyncChat queues a sync of one chat ahead of the regular passes. Private
+// chats are synced by the account owning them; for group chats account_id
+// picks the account, defaulting to the one that synced the chat last. Bots
+// and channels, which only bots archive, can't be synced on demand.
+func (h *Handler) SyncChat(ctx *gin.Context) {
+	chatUUID, err := uuid.Parse(ctx.Param("chatID"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat id"})
+		return
+	}
+	accountID, ok := h.accountParam(ctx)
+	if !ok {
+		return
+	}
+
+	var chat db.Chat
+	if err := h.DB.Conn.First(&chat, "id = ?", chatUUID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Chat not found"})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if chat.PeerType == "channel" {
+		ctx.JSON(http.StatusConflict, gin.H{"error": "Channels are archived by bots from updates and can't be synced on demand"})
+		return
+	}
+
+	switch {
+	case chat.AccountID != nil:
+		accountID = chat.AccountID
+	case accountID == nil:
+		var state db.SyncState
+		err := h.DB.Conn.Where("chat_id = ?", chat.ID).Order("last_run_at DESC NULLS LAST").First(&state).Error
+		if err == nil {
+			accountID = &state.AccountID
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+
+	if accountID != nil {
+		bot, err := h.isBotAccount(*accountID)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if bot {
+			ctx.JSON(http.StatusConflict, gin.H{"error": "Bot accounts archive from updates and can't run sync jobs"})
+			return
+		}
+	}
+
+	job := db.SyncJob{
+		ChatID:      &chat.ID,
+		AccountID:   accountID,
+		Priority:    db.PriorityChatSync,
+		RequestedBy: requestActor(ctx),
+	}
+	if err := h.DB.EnqueueSyncJob(&job); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	ctx.JSON(http.StatusAccepted, gin.H{"data": syncJobResponse(&job)})
+}
+
+// SyncAll queues a full pass for the account given by account_id, or for
+// every configured account except bots.
+func (h *Handler) SyncAll(ctx *gin.Context) {
+	accountID, ok := h.accountParam(ctx)
+	if !ok {
+		return
+	}
+
+	var accounts []db.Account
+	query := h.DB.Conn.Select("id", "name")
+	if accountID != nil {
+		query = query.Where("id = ?", *accountID)
+	}
+	if err := query.Find(&accounts).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	// Accounts removed from the configuration stay in the database, but no
+	// client would ever claim their jobs.
+	var accountIDs []uuid.UUID
+	for _, account := range accounts {
+		bot, configured := h.Accounts[account.Name]
+		if configured && !bot {
+			accountIDs = append(accountIDs, account.ID)
+			continue
+		}
+		if accountID == nil {
+			continue
+		}
+		if !configured {
+			ctx.JSON(http.StatusConflict, gin.H{"error": "Account is no longer configured"})
+			return
+		}
+		ctx.JSON(http.StatusConflict, gin.H{"error": "Bot accounts archive from updates and can't run sync jobs"})
+		return
+	}
+
+	response := make([]SyncJobResponse, len(accountIDs))
+	for i := range accountIDs {
+		job := db.SyncJob{
+			AccountID:   &accountIDs[i],
+			Priority:    db.PriorityFullSync,
+			RequestedBy: requestActor(ctx),
+		}
+		if err := h.DB.EnqueueSyncJob(&job); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		response[i] = syncJobResponse(&job)
+	}
+	ctx.JSON(http.StatusAccepted, gin.H{"data": response})
+}
+
+func (h *Handler) GetSyncJobs(ctx *gin.Context) {
+	page, perPage, ok := h.pagination(ctx)
+	if !ok {
+		return
+	}
+
+	query := h.DB.Conn.Model(&db.SyncJob{})
+	if status := ctx.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	var jobs []db.SyncJob
+	if err := query.
+		Order("created_at DESC").
+		Limit(perPage).
+		Offset((page - 1) * perPage).
+		Find(&jobs).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	response := make([]SyncJobResponse, len(jobs))
+	for i := range jobs {
+		response[i] = syncJobResponse(&jobs[i])
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"data": response,
+		"meta": gin.H{
+			"page":       page,
+			"per_page":   perPage,
+			"total":      total,
+			"totalPages": (int(total) + perPage - 1) / perPage,
+		},
+	})
+}
+
+func (h *Handler) GetSyncJob(ctx *gin.Context) {
+	jobID, err := uuid.Parse(ctx.Param("jobID"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job id"})
+		return
+	}
+
+	var job db.SyncJob
+	if err := h.DB.Conn.First(&job, "id = ?", jobID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"data": syncJobResponse(&job)})
+}
+
+// accountParam parses the optional account_id query parameter and checks that
+// the account exists, writing an error response and returning false if not.
+func (h *Handler) accountParam(ctx *gin.Context) (*uuid.UUID, bool) {
+	raw := ctx.Query("account_id")
+	if raw == "" {
+		return nil, true
+	}
+	accountID, err := uuid.Parse(raw)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account id"})
+		return nil, false
+	}
+	var count int64
+	if err := h.DB.Conn.Model(&db.Account{}).Where("id = ?", accountID).Count(&count).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return nil, false
+	}
+	if count == 0 {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return nil, false
+	}
+	return &accountID, true
+}
+
+// isBotAccount reports whether the account is configured as a bot.
+func (h *Handler) isBotAccount(accountID uuid.UUID) (bool, error) {
+	var account db.Account
+	if err := h.DB.Conn.Select("name").First(&account, "id = ?", accountID).Error; err != nil {
+		return false, err
+	}
+	return h.Accounts[account.Name], nil
+}
+
+func syncJobResponse(job *db.SyncJob) SyncJobResponse {
+	response := SyncJobResponse{
+		ID:          job.ID.String(),
+		ChatID:      uuidString(job.ChatID),
+		AccountID:   uuidString(job.AccountID),
+		Priority:    job.Priority,
+		Status:      job.Status,
+		Error:       job.Error,
+		RequestedBy: job.RequestedBy,
+		CreatedAt:   job.CreatedAt.Format(time.RFC3339),
+	}
+	if job.StartedAt != nil {
+		response.StartedAt = job.StartedAt.Format(time.RFC3339)
+	}
+	if job.FinishedAt != nil {
+		response.FinishedAt = job.FinishedAt.Format(time.RFC3339)
+	}
+	return response
+}
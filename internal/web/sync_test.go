@@ -0,0 +1,72 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"tmd/internal/db"
+)
+
+func TestSyncAll(t *testing.T) {
+	database := newTestDB(t)
+	ids := make(map[string]string)
+	for _, name := range []string{"me", "bot", "removed"} {
+		account, err := database.EnsureAccount(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[name] = account.ID.String()
+	}
+	handler := &Handler{DB: database, Accounts: map[string]bool{"me": false, "bot": true}}
+	r := gin.New()
+	r.POST("/api/v1/sync", handler.SyncAll)
+
+	tests := []struct {
+		name     string
+		query    string
+		status   int
+		accounts []string
+	}{
+		{"every account", "", http.StatusAccepted, []string{ids["me"]}},
+		{"one account", "?account_id=" + ids["me"], http.StatusAccepted, []string{ids["me"]}},
+		{"bot", "?account_id=" + ids["bot"], http.StatusConflict, nil},
+		{"removed from the configuration", "?account_id=" + ids["removed"], http.StatusConflict, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := database.Conn.Where("1 = 1").Delete(&db.SyncJob{}).Error; err != nil {
+				t.Fatal(err)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/sync"+tt.query, nil))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+
+			var jobs []db.SyncJob
+			if err := database.Conn.Find(&jobs).Error; err != nil {
+				t.Fatal(err)
+			}
+			if len(jobs) != len(tt.accounts) {
+				t.Fatalf("queued %d jobs, want %d", len(jobs), len(tt.accounts))
+			}
+			if tt.status != http.StatusAccepted {
+				return
+			}
+			var body struct {
+				Data []SyncJobResponse `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			for i, job := range jobs {
+				if job.AccountID == nil || job.AccountID.String() != tt.accounts[i] || body.Data[i].AccountID != tt.accounts[i] {
+					t.Errorf("job %d = %+v, response %+v", i, job, body.Data[i])
+				}
+			}
+		})
+	}
+}
@@ -2,22 +2,80 @@ package cfg
 
 import (
 	"errors"
-	"gopkg.in/yaml.v3"
+	"fmt"
+	"net"
 	"os"
+	"time"
+
+	"github.com/ulule/limiter/v3"
+	"gopkg.in/yaml.v3"
 )
 
+// TelegramAccount is one Telegram user archived by this deployment.
+type TelegramAccount struct {
+	Name        string `yaml:"name"`
+	PhoneNumber string `yaml:"phone_number"`
+	Password    string `yaml:"password"`
+	BotToken    string `yaml:"bot_token"`
+}
+
+// IsBot reports whether the account logs in with a bot token.
+func (a TelegramAccount) IsBot() bool {
+	return a.BotToken != ""
+}
+
 type Config struct {
 	Telegram struct {
 		PhoneNumber string `yaml:"phone_number"`
 		ApiID       int    `yaml:"api_id"`
 		ApiHash     string `yaml:"api_hash"`
 		Password    string `yaml:"password"`
+		BotToken    string `yaml:"bot_token"`
+		LoginMethod string `yaml:"login_method"`
+		Prompt      string `yaml:"prompt"`
+		Session     struct {
+			Backend string `yaml:"backend"`
+			Path    string `yaml:"path"`
+			Key     string `yaml:"key"`
+		} `yaml:"session"`
+		Accounts  []TelegramAccount `yaml:"accounts"`
+		RateLimit struct {
+			Requests      float64 `yaml:"requests"`
+			Burst         int     `yaml:"burst"`
+			Downloads     float64 `yaml:"downloads"`
+			DownloadBurst int     `yaml:"download_burst"`
+		} `yaml:"rate_limit"`
+		FloodWait struct {
+			// MaxRetries is a pointer so 0 can turn retries off.
+			MaxRetries *int          `yaml:"max_retries"`
+			MaxWait    time.Duration `yaml:"max_wait"`
+		} `yaml:"flood_wait"`
 	} `yaml:"telegram"`
 
 	Download struct {
 		BaseDir string `yaml:"base_dir"`
 	} `yaml:"download"`
 
+	Storage struct {
+		Backend string `yaml:"backend"`
+	} `yaml:"storage"`
+
+	Verify struct {
+		Interval       time.Duration `yaml:"interval"`
+		Checksums      bool          `yaml:"checksums"`
+		RequeueMissing bool          `yaml:"requeue_missing"`
+		DeleteOrphans  bool          `yaml:"delete_orphans"`
+		// OrphanGrace is a pointer so 0 can turn the grace period off.
+		OrphanGrace *time.Duration `yaml:"orphan_grace"`
+	} `yaml:"verify"`
+
+	Encryption struct {
+		MasterKey     string `yaml:"master_key"`
+		MasterKeyFile string `yaml:"master_key_file"`
+		Media         bool   `yaml:"media"`
+		Content       bool   `yaml:"content"`
+	} `yaml:"encryption"`
+
 	Logging struct {
 		Filename   string `yaml:"filename"`
 		MaxSize    int    `yaml:"max_size"`
@@ -28,8 +86,11 @@ type Config struct {
 	} `yaml:"logging"`
 
 	Fetching struct {
-		DialogsLimit  int `yaml:"dialogs_limit"`
-		MessagesLimit int `yaml:"messages_limit"`
+		DialogsLimit  int           `yaml:"dialogs_limit"`
+		MessagesLimit int           `yaml:"messages_limit"`
+		DialogWorkers int           `yaml:"dialog_workers"`
+		MediaWorkers  int           `yaml:"media_workers"`
+		DrainTimeout  time.Duration `yaml:"drain_timeout"`
 	} `yaml:"fetching"`
 
 	Database struct {
@@ -40,16 +101,165 @@ type Config struct {
 		Password string `yaml:"password"`
 		DBName   string `yaml:"dbname"`
 		SSLMode  string `yaml:"sslmode"`
+		Path     string `yaml:"path"`
+		LogLevel string `yaml:"log_level"`
 	} `yaml:"database"`
 
 	Minio struct {
-		Endpoint  string `yaml:"endpoint"`
-		AccessKey string `yaml:"access_key"`
-		SecretKey string `yaml:"secret_key"`
-		Bucket    string `yaml:"bucket"`
-		BasePath  string `yaml:"base_path"`
-		UseSSL    bool   `yaml:"use_ssl"`
+		Endpoint   string `yaml:"endpoint"`
+		AccessKey  string `yaml:"access_key"`
+		SecretKey  string `yaml:"secret_key"`
+		Bucket     string `yaml:"bucket"`
+		BasePath   string `yaml:"base_path"`
+		UseSSL     bool   `yaml:"use_ssl"`
+		Versioning bool   `yaml:"versioning"`
+		ObjectLock bool   `yaml:"object_lock"`
 	} `yaml:"minio"`
+
+	S3 struct {
+		Endpoint     string `yaml:"endpoint"`
+		Region       string `yaml:"region"`
+		AccessKey    string `yaml:"access_key"`
+		SecretKey    string `yaml:"secret_key"`
+		Bucket       string `yaml:"bucket"`
+		BasePath     string `yaml:"base_path"`
+		DisableSSL   bool   `yaml:"disable_ssl"`
+		BucketLookup string `yaml:"bucket_lookup"`
+		StorageClass string `yaml:"storage_class"`
+		SSE          string `yaml:"sse"`
+		KMSKeyID     string `yaml:"kms_key_id"`
+		Versioning   bool   `yaml:"versioning"`
+		ObjectLock   bool   `yaml:"object_lock"`
+	} `yaml:"s3"`
+
+	Metrics struct {
+		// Addr is where `tmd sync` serves the probes and metrics that
+		// run and serve expose on web.addr.
+		Addr string `yaml:"addr"`
+	} `yaml:"metrics"`
+
+	Web struct {
+		Addr            string            `yaml:"addr"`
+		TLSCert         string            `yaml:"tls_cert"`
+		TLSKey          string            `yaml:"tls_key"`
+		TrustedProxies  []string          `yaml:"trusted_proxies"`
+		CORSOrigins     []string          `yaml:"cors_origins"`
+		RateLimit       string            `yaml:"rate_limit"`
+		RouteRateLimits map[string]string `yaml:"route_rate_limits"`
+		PageSize        int               `yaml:"page_size"`
+		MaxPageSize     int               `yaml:"max_page_size"`
+		MediaMode       string            `yaml:"media_mode"`
+		ShutdownTimeout time.Duration     `yaml:"shutdown_timeout"`
+		AdminToken      string            `yaml:"admin_token"`
+	} `yaml:"web"`
+}
+
+func (cfg *Config) applyDefaults() {
+	if len(cfg.Telegram.Accounts) == 0 {
+		cfg.Telegram.Accounts = []TelegramAccount{{
+			PhoneNumber: cfg.Telegram.PhoneNumber,
+			Password:    cfg.Telegram.Password,
+			BotToken:    cfg.Telegram.BotToken,
+		}}
+	}
+	for i := range cfg.Telegram.Accounts {
+		account := &cfg.Telegram.Accounts[i]
+		if account.Name == "" {
+			account.Name = account.PhoneNumber
+		}
+		if account.Name == "" && account.IsBot() {
+			account.Name = "bot"
+		}
+		if account.Name == "" {
+			account.Name = "default"
+		}
+	}
+	if cfg.Telegram.LoginMethod == "" {
+		cfg.Telegram.LoginMethod = "code"
+	}
+	if cfg.Telegram.Prompt == "" {
+		cfg.Telegram.Prompt = "stdin"
+	}
+	if cfg.Telegram.Session.Backend == "" {
+		cfg.Telegram.Session.Backend = "database"
+	}
+	if cfg.Telegram.Session.Path == "" {
+		cfg.Telegram.Session.Path = "tmd.session"
+	}
+	if cfg.Telegram.RateLimit.Requests == 0 {
+		cfg.Telegram.RateLimit.Requests = 5
+	}
+	if cfg.Telegram.RateLimit.Burst == 0 {
+		cfg.Telegram.RateLimit.Burst = 5
+	}
+	if cfg.Telegram.RateLimit.Downloads == 0 {
+		cfg.Telegram.RateLimit.Downloads = 10
+	}
+	if cfg.Telegram.RateLimit.DownloadBurst == 0 {
+		cfg.Telegram.RateLimit.DownloadBurst = 10
+	}
+	if cfg.Telegram.FloodWait.MaxRetries == nil {
+		maxRetries := 5
+		cfg.Telegram.FloodWait.MaxRetries = &maxRetries
+	}
+	if cfg.Telegram.FloodWait.MaxWait == 0 {
+		cfg.Telegram.FloodWait.MaxWait = time.Minute
+	}
+	if cfg.Download.BaseDir == "" {
+		cfg.Download.BaseDir = "tmd"
+	}
+	if cfg.Storage.Backend == "" {
+		cfg.Storage.Backend = "minio"
+	}
+	if cfg.Verify.OrphanGrace == nil {
+		orphanGrace := time.Hour
+		cfg.Verify.OrphanGrace = &orphanGrace
+	}
+	if cfg.S3.Endpoint == "" {
+		cfg.S3.Endpoint = "s3.amazonaws.com"
+	}
+	if cfg.S3.BucketLookup == "" {
+		cfg.S3.BucketLookup = "auto"
+	}
+	if cfg.Fetching.DialogWorkers == 0 {
+		cfg.Fetching.DialogWorkers = 4
+	}
+	if cfg.Fetching.MediaWorkers == 0 {
+		cfg.Fetching.MediaWorkers = 5
+	}
+	if cfg.Fetching.DrainTimeout == 0 {
+		cfg.Fetching.DrainTimeout = 30 * time.Second
+	}
+	if cfg.Database.LogLevel == "" {
+		cfg.Database.LogLevel = "warn"
+	}
+	if cfg.Database.Dialect == "sqlite" && cfg.Database.Path == "" {
+		cfg.Database.Path = "tmd.db"
+	}
+	if cfg.Web.Addr == "" {
+		cfg.Web.Addr = ":8083"
+	}
+	if cfg.Metrics.Addr == "" {
+		cfg.Metrics.Addr = ":9464"
+	}
+	if len(cfg.Web.CORSOrigins) == 0 {
+		cfg.Web.CORSOrigins = []string{"http://localhost:3003", "https://tmd-nanana.com"}
+	}
+	if cfg.Web.RateLimit == "" {
+		cfg.Web.RateLimit = "100-M"
+	}
+	if cfg.Web.PageSize == 0 {
+		cfg.Web.PageSize = 50
+	}
+	if cfg.Web.MaxPageSize == 0 {
+		cfg.Web.MaxPageSize = 200
+	}
+	if cfg.Web.MediaMode == "" {
+		cfg.Web.MediaMode = "presigned"
+	}
+	if cfg.Web.ShutdownTimeout == 0 {
+		cfg.Web.ShutdownTimeout = 10 * time.Second
+	}
 }
 
 func (cfg *Config) Validate() error {
@@ -59,29 +269,146 @@ func (cfg *Config) Validate() error {
 	if cfg.Telegram.ApiHash == "" {
 		return errors.New("telegram.api_hash is required")
 	}
-	if cfg.Telegram.PhoneNumber == "" {
-		return errors.New("telegram.phone_number is required")
+	if cfg.Telegram.LoginMethod != "code" && cfg.Telegram.LoginMethod != "qr" {
+		return errors.New("telegram.login_method must be either code or qr")
+	}
+	if cfg.Telegram.Prompt != "stdin" && cfg.Telegram.Prompt != "web" {
+		return errors.New("telegram.prompt must be either stdin or web")
+	}
+	// A QR login always shows its code on the /login page, whatever the prompt.
+	if (cfg.Telegram.Prompt == "web" || cfg.Telegram.LoginMethod == "qr") && cfg.Web.AdminToken == "" {
+		return errors.New("web.admin_token is required when telegram.prompt is web or telegram.login_method is qr")
 	}
-	if cfg.Database.Dialect == "" {
+	names := make(map[string]bool, len(cfg.Telegram.Accounts))
+	for _, account := range cfg.Telegram.Accounts {
+		if names[account.Name] {
+			return fmt.Errorf("telegram.accounts: duplicate account name %q", account.Name)
+		}
+		names[account.Name] = true
+		if account.IsBot() && account.PhoneNumber != "" {
+			return fmt.Errorf("telegram account %q: set either phone_number or bot_token, not both", account.Name)
+		}
+		if !account.IsBot() && account.PhoneNumber == "" && cfg.Telegram.LoginMethod == "code" && cfg.Telegram.Prompt == "stdin" {
+			return fmt.Errorf("telegram.phone_number is required for account %q", account.Name)
+		}
+	}
+	if cfg.Telegram.RateLimit.Requests < 0 || cfg.Telegram.RateLimit.Downloads < 0 {
+		return errors.New("telegram.rate_limit: requests and downloads must be positive")
+	}
+	if cfg.Telegram.RateLimit.Burst < 1 || cfg.Telegram.RateLimit.DownloadBurst < 1 {
+		return errors.New("telegram.rate_limit: burst and download_burst must be at least 1")
+	}
+	if *cfg.Telegram.FloodWait.MaxRetries < 0 {
+		return errors.New("telegram.flood_wait.max_retries must not be negative")
+	}
+	if cfg.Fetching.DialogWorkers < 1 || cfg.Fetching.MediaWorkers < 1 {
+		return errors.New("fetching: dialog_workers and media_workers must be at least 1")
+	}
+	switch cfg.Telegram.Session.Backend {
+	case "database":
+	case "file":
+		if cfg.Telegram.Session.Key == "" {
+			return errors.New("telegram.session.key is required for the file session backend")
+		}
+	default:
+		return fmt.Errorf("telegram.session.backend: unsupported backend %q", cfg.Telegram.Session.Backend)
+	}
+	switch cfg.Database.Dialect {
+	case "postgres":
+		if cfg.Database.Host == "" {
+			return errors.New("database.host is required")
+		}
+		if cfg.Database.Port == 0 {
+			return errors.New("database.port is required")
+		}
+		if cfg.Database.DBName == "" {
+			return errors.New("database.dbname is required")
+		}
+	case "sqlite":
+	case "":
 		return errors.New("database.dialect is required")
+	default:
+		return fmt.Errorf("database.dialect: unsupported dialect %q, expected postgres or sqlite", cfg.Database.Dialect)
+	}
+	switch cfg.Database.LogLevel {
+	case "silent", "error", "warn", "info":
+	default:
+		return errors.New("database.log_level must be one of silent, error, warn or info")
+	}
+	switch cfg.Storage.Backend {
+	case "minio":
+		if cfg.Minio.Endpoint == "" {
+			return errors.New("minio.endpoint is required")
+		}
+		if cfg.Minio.AccessKey == "" || cfg.Minio.SecretKey == "" {
+			return errors.New("minio.access_key and minio.secret_key are required")
+		}
+		if cfg.Minio.Bucket == "" {
+			return errors.New("minio.bucket is required")
+		}
+	case "s3":
+		if cfg.S3.Bucket == "" {
+			return errors.New("s3.bucket is required")
+		}
+		if (cfg.S3.AccessKey == "") != (cfg.S3.SecretKey == "") {
+			return errors.New("s3.access_key and s3.secret_key must be set together")
+		}
+		switch cfg.S3.BucketLookup {
+		case "auto", "path", "dns":
+		default:
+			return errors.New("s3.bucket_lookup must be one of auto, path or dns")
+		}
+		switch cfg.S3.SSE {
+		case "", "s3":
+		case "kms":
+			if cfg.S3.KMSKeyID == "" {
+				return errors.New("s3.kms_key_id is required when s3.sse is kms")
+			}
+		default:
+			return errors.New("s3.sse must be empty, s3 or kms")
+		}
+	case "local":
+	default:
+		return fmt.Errorf("storage.backend: unsupported backend %q, expected minio, s3 or local", cfg.Storage.Backend)
 	}
-	if cfg.Database.Host == "" {
-		return errors.New("database.host is required")
+	if cfg.Verify.Interval < 0 || *cfg.Verify.OrphanGrace < 0 {
+		return errors.New("verify: interval and orphan_grace must not be negative")
 	}
-	if cfg.Database.Port == 0 {
-		return errors.New("database.port is required")
+	if cfg.Encryption.MasterKey != "" && cfg.Encryption.MasterKeyFile != "" {
+		return errors.New("encryption: set either master_key or master_key_file, not both")
 	}
-	if cfg.Database.DBName == "" {
-		return errors.New("database.dbname is required")
+	if (cfg.Encryption.Media || cfg.Encryption.Content) && cfg.Encryption.MasterKey == "" && cfg.Encryption.MasterKeyFile == "" {
+		return errors.New("encryption.master_key or encryption.master_key_file is required to encrypt media or content")
 	}
-	if cfg.Minio.Endpoint == "" {
-		return errors.New("minio.endpoint is required")
+	if (cfg.Web.TLSCert == "") != (cfg.Web.TLSKey == "") {
+		return errors.New("web.tls_cert and web.tls_key must be set together")
 	}
-	if cfg.Minio.AccessKey == "" || cfg.Minio.SecretKey == "" {
-		return errors.New("minio.access_key and minio.secret_key are required")
+	if cfg.Web.PageSize < 1 || cfg.Web.MaxPageSize < cfg.Web.PageSize {
+		return errors.New("web.page_size must be positive and not exceed web.max_page_size")
 	}
-	if cfg.Minio.Bucket == "" {
-		return errors.New("minio.bucket is required")
+	if cfg.Web.MediaMode != "presigned" && cfg.Web.MediaMode != "proxy" {
+		return errors.New("web.media_mode must be either presigned or proxy")
+	}
+	if _, err := limiter.NewRateFromFormatted(cfg.Web.RateLimit); err != nil {
+		return fmt.Errorf("web.rate_limit: %w", err)
+	}
+	for route, formatted := range cfg.Web.RouteRateLimits {
+		switch route {
+		case "chats", "messages", "files", "sync", "admin":
+		default:
+			return fmt.Errorf("web.route_rate_limits: unknown route %q", route)
+		}
+		if _, err := limiter.NewRateFromFormatted(formatted); err != nil {
+			return fmt.Errorf("web.route_rate_limits.%s: %w", route, err)
+		}
+	}
+	for _, proxy := range cfg.Web.TrustedProxies {
+		if net.ParseIP(proxy) != nil {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(proxy); err != nil {
+			return fmt.Errorf("web.trusted_proxies: %q is neither an IP address nor a CIDR", proxy)
+		}
 	}
 	return nil
 }
@@ -100,9 +427,21 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, err
 	}
 
+	cfg.applyDefaults()
+
 	if err := cfg.Validate(); err != nil {
 		return nil, err
 	}
 
 	return &cfg, nil
 }
+
+// Account returns the configured account with the given name.
+func (cfg *Config) Account(name string) (TelegramAccount, error) {
+	for _, account := range cfg.Telegram.Accounts {
+		if account.Name == name {
+			return account, nil
+		}
+	}
+	return TelegramAccount{}, fmt.Errorf("unknown telegram account %q", name)
+}
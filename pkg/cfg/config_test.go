@@ -0,0 +1,119 @@
+package cfg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validConfig returns a minimal configuration that passes Validate.
+func validConfig() *Config {
+	var c Config
+	c.Telegram.ApiID = 1
+	c.Telegram.ApiHash = "hash"
+	c.Telegram.PhoneNumber = "+1234567890"
+	c.Database.Dialect = "sqlite"
+	c.Storage.Backend = "local"
+	c.applyDefaults()
+	return &c
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		err    string
+	}{
+		{"defaults", func(*Config) {}, ""},
+		{"bad rate limit", func(c *Config) { c.Web.RateLimit = "100 per minute" }, "web.rate_limit"},
+		{"bad route rate limit", func(c *Config) {
+			c.Web.RouteRateLimits = map[string]string{"files": "300-M", "sync": "ten-M"}
+		}, "web.route_rate_limits.sync"},
+		{"unknown route", func(c *Config) { c.Web.RouteRateLimits = map[string]string{"media": "1-S"} }, "unknown route"},
+		{"route rate limits", func(c *Config) {
+			c.Web.RouteRateLimits = map[string]string{"files": "300-M", "admin": "5-S"}
+		}, ""},
+		{"trusted proxies", func(c *Config) { c.Web.TrustedProxies = []string{"10.0.0.0/8", "192.0.2.1", "::1"} }, ""},
+		{"bad trusted proxy", func(c *Config) { c.Web.TrustedProxies = []string{"10.0.0.0/8", "proxy.local"} }, "web.trusted_proxies"},
+		{"web prompt without admin token", func(c *Config) { c.Telegram.Prompt = "web" }, "web.admin_token"},
+		{"QR login without admin token", func(c *Config) { c.Telegram.LoginMethod = "qr" }, "web.admin_token"},
+		{"QR login with admin token", func(c *Config) {
+			c.Telegram.LoginMethod = "qr"
+			c.Web.AdminToken = "secret"
+		}, ""},
+		{"bad trusted proxy CIDR", func(c *Config) { c.Web.TrustedProxies = []string{"10.0.0.0/33"} }, "web.trusted_proxies"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validConfig()
+			tt.modify(c)
+			err := c.Validate()
+			if tt.err == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("error = %v, want one mentioning %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestDefaultCORSOrigins(t *testing.T) {
+	c := validConfig()
+	want := []string{"http://localhost:3003", "https://tmd-nanana.com"}
+	if !slices.Equal(c.Web.CORSOrigins, want) {
+		t.Errorf("default CORS origins = %v, want %v", c.Web.CORSOrigins, want)
+	}
+}
+
+func TestExplicitZeroes(t *testing.T) {
+	tests := []struct {
+		name        string
+		floodWait   string
+		verify      string
+		maxRetries  int
+		orphanGrace time.Duration
+	}{
+		{"defaults", "", "", 5, time.Hour},
+		{"zeroes", "max_retries: 0", "orphan_grace: 0s", 0, 0},
+		{"set", "max_retries: 2", "orphan_grace: 10m", 2, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := fmt.Sprintf(`
+telegram:
+  api_id: 1
+  api_hash: hash
+  phone_number: "+1234567890"
+  flood_wait: {%s}
+database:
+  dialect: sqlite
+storage:
+  backend: local
+verify: {%s}
+`, tt.floodWait, tt.verify)
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			c, err := LoadConfig(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *c.Telegram.FloodWait.MaxRetries != tt.maxRetries || *c.Verify.OrphanGrace != tt.orphanGrace {
+				t.Errorf("max_retries = %d, orphan_grace = %v", *c.Telegram.FloodWait.MaxRetries, *c.Verify.OrphanGrace)
+			}
+		})
+	}
+
+	c := validConfig()
+	maxRetries := -1
+	c.Telegram.FloodWait.MaxRetries = &maxRetries
+	if err := c.Validate(); err == nil {
+		t.Error("negative max_retries passed validation")
+	}
+}
@@ -0,0 +1,101 @@
+// Package envelope implements envelope encryption: every object is encrypted
+// with its own random data key, which is stored next to it wrapped by the
+// master key.
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var ErrWrongKey = errors.New("data was encrypted with a different master key")
+
+// MasterKey wraps data keys and seals short values such as message content.
+type MasterKey struct {
+	aead cipher.AEAD
+	id   [keyIDSize]byte
+}
+
+const keyIDSize = 8
+
+// LoadMasterKey reads the master key from secret or, when that is empty, from
+// the file at path, such as one written by a KMS or secrets agent. Any string
+// is accepted; the key is derived from it with SHA-256.
+func LoadMasterKey(secret, path string) (*MasterKey, error) {
+	if secret == "" && path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read master key file: %w", err)
+		}
+		secret = strings.TrimSpace(string(data))
+	}
+	if secret == "" {
+		return nil, errors.New("master key is empty")
+	}
+
+	key := sha256.Sum256([]byte(secret))
+	aead, err := newAEAD(key[:])
+	if err != nil {
+		return nil, err
+	}
+	m := &MasterKey{aead: aead}
+	id := sha256.Sum256(key[:])
+	copy(m.id[:], id[:])
+	return m, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("envelope cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("envelope cipher: %w", err)
+	}
+	return aead, nil
+}
+
+// contentPrefix marks sealed strings, so values stored before encryption was
+// enabled are still read as plaintext.
+const contentPrefix = "enc:v1:"
+
+// SealString encrypts s into a printable string.
+func (m *MasterKey) SealString(s string) (string, error) {
+	nonce := make([]byte, m.aead.NonceSize(), m.aead.NonceSize()+len(s)+m.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("envelope nonce: %w", err)
+	}
+	sealed := m.aead.Seal(nonce, nonce, []byte(s), m.id[:])
+	return contentPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// IsSealed reports whether s was produced by SealString.
+func IsSealed(s string) bool {
+	return strings.HasPrefix(s, contentPrefix)
+}
+
+// OpenString decrypts a string sealed by SealString.
+func (m *MasterKey) OpenString(s string) (string, error) {
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(s, contentPrefix))
+	if err != nil {
+		return "", fmt.Errorf("decode sealed value: %w", err)
+	}
+	nonceSize := m.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return "", errors.New("sealed value is too short")
+	}
+	plain, err := m.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], m.id[:])
+	if err != nil {
+		return "", ErrWrongKey
+	}
+	return string(plain), nil
+}
@@ -0,0 +1,265 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// An encrypted object starts with a header holding the wrapped data key,
+// followed by the plaintext in chunks of chunkSize bytes, each sealed with
+// AES-GCM. A chunk's nonce encodes its index and whether it is the last one,
+// so chunks can't be reordered or the object truncated unnoticed, and any
+// chunk can be decrypted on its own to serve byte ranges.
+const (
+	chunkSize   = 64 << 10
+	tagSize     = 16
+	noncePrefix = 7
+
+	dataKeySize    = 32
+	wrapNonceSize  = 12
+	wrappedKeySize = wrapNonceSize + dataKeySize + tagSize
+	headerSize     = len(magic) + 1 + keyIDSize + wrappedKeySize + noncePrefix
+)
+
+const (
+	magic   = "TMDE"
+	version = 1
+)
+
+var (
+	ErrCorrupt = errors.New("encrypted object is corrupt")
+	// ErrNotEncrypted is returned by Decrypt for data without the header.
+	ErrNotEncrypted = errors.New("data is not encrypted")
+)
+
+// EncryptedSize returns the size of the encrypted form of size bytes.
+func EncryptedSize(size int64) int64 {
+	return int64(headerSize) + size + chunkCount(size)*tagSize
+}
+
+// PlainSize returns the plaintext size of an encrypted object of size bytes.
+func PlainSize(size int64) (int64, error) {
+	body := size - int64(headerSize)
+	if body < tagSize {
+		return 0, ErrCorrupt
+	}
+	chunks := (body + chunkSize + tagSize - 1) / (chunkSize + tagSize)
+	plain := body - chunks*tagSize
+	if plain < 0 || chunkCount(plain) != chunks {
+		return 0, ErrCorrupt
+	}
+	return plain, nil
+}
+
+func chunkCount(size int64) int64 {
+	return max((size+chunkSize-1)/chunkSize, 1)
+}
+
+func chunkNonce(prefix []byte, index int64, last bool) []byte {
+	nonce := make([]byte, noncePrefix+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefix:], uint32(index))
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// Encrypt returns a reader producing the encrypted form of the size bytes
+// read from r, under a fresh data key.
+func (m *MasterKey) Encrypt(r io.Reader, size int64) (io.Reader, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, fmt.Errorf("envelope data key: %w", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, headerSize)
+	header = append(header, magic...)
+	header = append(header, version)
+	header = append(header, m.id[:]...)
+	wrapNonce := make([]byte, wrapNonceSize)
+	if _, err := io.ReadFull(rand.Reader, wrapNonce); err != nil {
+		return nil, fmt.Errorf("envelope nonce: %w", err)
+	}
+	header = append(header, wrapNonce...)
+	header = m.aead.Seal(header, wrapNonce, dataKey, header[:len(magic)+1+keyIDSize])
+	prefix := make([]byte, noncePrefix)
+	if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+		return nil, fmt.Errorf("envelope nonce: %w", err)
+	}
+	header = append(header, prefix...)
+
+	return &encryptReader{
+		src:    r,
+		aead:   aead,
+		prefix: prefix,
+		chunks: chunkCount(size),
+		out:    *bytes.NewBuffer(header),
+		plain:  make([]byte, chunkSize),
+	}, nil
+}
+
+type encryptReader struct {
+	src    io.Reader
+	aead   cipher.AEAD
+	prefix []byte
+	chunks int64
+	next   int64
+	out    bytes.Buffer
+	plain  []byte
+}
+
+func (e *encryptReader) Read(p []byte) (int, error) {
+	for e.out.Len() == 0 {
+		if e.next == e.chunks {
+			return 0, io.EOF
+		}
+		last := e.next == e.chunks-1
+		n, err := io.ReadFull(e.src, e.plain)
+		if err != nil && err != io.ErrUnexpectedEOF && !(err == io.EOF && last) {
+			return 0, fmt.Errorf("read plaintext: %w", err)
+		}
+		if n < chunkSize && !last {
+			return 0, io.ErrUnexpectedEOF
+		}
+		e.out.Write(e.aead.Seal(nil, chunkNonce(e.prefix, e.next, last), e.plain[:n], nil))
+		e.next++
+	}
+	return e.out.Read(p)
+}
+
+// IsEncrypted reports whether r starts with the header of an encrypted
+// object.
+func IsEncrypted(r io.Reader) (bool, error) {
+	prefix := make([]byte, len(magic))
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, nil
+		}
+		return false, err
+	}
+	return string(prefix) == magic, nil
+}
+
+// Decrypt returns a seekable reader over the plaintext of the encrypted
+// object src of size bytes.
+func (m *MasterKey) Decrypt(src io.ReadSeeker, size int64) (io.ReadSeeker, int64, error) {
+	header := make([]byte, headerSize)
+	n, err := io.ReadFull(src, header)
+	if n < len(magic) || string(header[:len(magic)]) != magic {
+		return nil, 0, ErrNotEncrypted
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("read header: %w", err)
+	}
+	if header[len(magic)] != version {
+		return nil, 0, ErrCorrupt
+	}
+	plainSize, err := PlainSize(size)
+	if err != nil {
+		return nil, 0, err
+	}
+	idEnd := len(magic) + 1 + keyIDSize
+	if !bytes.Equal(header[len(magic)+1:idEnd], m.id[:]) {
+		return nil, 0, ErrWrongKey
+	}
+	wrapNonce := header[idEnd : idEnd+wrapNonceSize]
+	wrapped := header[idEnd+wrapNonceSize : idEnd+wrappedKeySize]
+	dataKey, err := m.aead.Open(nil, wrapNonce, wrapped, header[:idEnd])
+	if err != nil {
+		return nil, 0, ErrCorrupt
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	d := &decryptReader{
+		src:    src,
+		aead:   aead,
+		prefix: header[idEnd+wrappedKeySize:],
+		size:   plainSize,
+		chunks: chunkCount(plainSize),
+		cached: -1,
+		buf:    make([]byte, chunkSize+tagSize),
+	}
+	// Reads of an empty plaintext never load its only chunk, which would
+	// leave it unauthenticated.
+	if plainSize == 0 {
+		if err := d.load(0); err != nil {
+			return nil, 0, err
+		}
+	}
+	return d, plainSize, nil
+}
+
+type decryptReader struct {
+	src    io.ReadSeeker
+	aead   cipher.AEAD
+	prefix []byte
+	size   int64
+	chunks int64
+	pos    int64
+	cached int64
+	plain  []byte
+	buf    []byte
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	if d.pos >= d.size {
+		return 0, io.EOF
+	}
+	index := d.pos / chunkSize
+	if index != d.cached {
+		if err := d.load(index); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain[d.pos-index*chunkSize:])
+	d.pos += int64(n)
+	return n, nil
+}
+
+func (d *decryptReader) load(index int64) error {
+	offset := int64(headerSize) + index*(chunkSize+tagSize)
+	if _, err := d.src.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	length := min(d.size-index*chunkSize, chunkSize) + tagSize
+	if _, err := io.ReadFull(d.src, d.buf[:length]); err != nil {
+		return fmt.Errorf("read chunk %d: %w", index, err)
+	}
+	plain, err := d.aead.Open(d.plain[:0], chunkNonce(d.prefix, index, index == d.chunks-1), d.buf[:length], nil)
+	if err != nil {
+		return ErrCorrupt
+	}
+	d.plain = plain
+	d.cached = index
+	return nil
+}
+
+func (d *decryptReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += d.pos
+	case io.SeekEnd:
+		offset += d.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	d.pos = offset
+	return offset, nil
+}
@@ -0,0 +1,203 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+func testKey(t *testing.T, secret string) *MasterKey {
+	t.Helper()
+	key, err := LoadMasterKey(secret, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func encrypt(t *testing.T, key *MasterKey, plain []byte) []byte {
+	t.Helper()
+	r, err := key.Encrypt(bytes.NewReader(plain), int64(len(plain)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealed
+}
+
+func decrypt(key *MasterKey, sealed []byte) ([]byte, error) {
+	r, _, err := key.Decrypt(bytes.NewReader(sealed), int64(len(sealed)))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestRoundTrip(t *testing.T) {
+	key := testKey(t, "secret")
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3 * chunkSize, 3*chunkSize + 100} {
+		plain := randomBytes(t, size)
+		sealed := encrypt(t, key, plain)
+		if int64(len(sealed)) != EncryptedSize(int64(size)) {
+			t.Errorf("size %d: encrypted to %d bytes, EncryptedSize = %d", size, len(sealed), EncryptedSize(int64(size)))
+		}
+		if got, err := PlainSize(int64(len(sealed))); err != nil || got != int64(size) {
+			t.Errorf("size %d: PlainSize = %d, %v", size, got, err)
+		}
+
+		r, plainSize, err := key.Decrypt(bytes.NewReader(sealed), int64(len(sealed)))
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if plainSize != int64(size) || !bytes.Equal(got, plain) {
+			t.Errorf("size %d: decrypted %d bytes, reported %d", size, len(got), plainSize)
+		}
+	}
+}
+
+func TestSeek(t *testing.T) {
+	key := testKey(t, "secret")
+	plain := randomBytes(t, 3*chunkSize+100)
+	sealed := encrypt(t, key, plain)
+	r, _, err := key.Decrypt(bytes.NewReader(sealed), int64(len(sealed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		offset int64
+		whence int
+		want   int64
+		n      int
+	}{
+		{0, io.SeekStart, 0, 10},
+		{chunkSize - 5, io.SeekStart, chunkSize - 5, 10},
+		{2 * chunkSize, io.SeekStart, 2 * chunkSize, chunkSize + 100},
+		{-50, io.SeekEnd, 3*chunkSize + 50, 50},
+		{-chunkSize, io.SeekCurrent, 2*chunkSize + 100, 1000},
+		{10, io.SeekStart, 10, 2 * chunkSize},
+	}
+	for _, tt := range tests {
+		pos, err := r.Seek(tt.offset, tt.whence)
+		if err != nil || pos != tt.want {
+			t.Fatalf("seek(%d, %d) = %d, %v, want %d", tt.offset, tt.whence, pos, err, tt.want)
+		}
+		got := make([]byte, tt.n)
+		if _, err := io.ReadFull(r, got); err != nil {
+			t.Fatalf("read %d bytes at %d: %v", tt.n, pos, err)
+		}
+		if !bytes.Equal(got, plain[pos:pos+int64(tt.n)]) {
+			t.Errorf("read %d bytes at %d: wrong plaintext", tt.n, pos)
+		}
+	}
+
+	if _, err := r.Seek(0, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := r.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Errorf("read at end = %d, %v", n, err)
+	}
+	if _, err := r.Seek(-1, io.SeekStart); err == nil {
+		t.Error("seek before the start succeeded")
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	key := testKey(t, "secret")
+	// With a plaintext of whole chunks, dropping the last chunk leaves an
+	// object of a valid size.
+	sealed := encrypt(t, key, randomBytes(t, 2*chunkSize))
+	for _, size := range []int{
+		len(sealed) - chunkSize - tagSize,
+		len(sealed) - 1,
+		len(sealed) - tagSize,
+		headerSize + tagSize,
+	} {
+		if _, err := decrypt(key, sealed[:size]); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("truncated to %d bytes: %v", size, err)
+		}
+	}
+}
+
+func TestReordered(t *testing.T) {
+	key := testKey(t, "secret")
+	sealed := encrypt(t, key, randomBytes(t, 3*chunkSize))
+	chunk := func(i int) []byte {
+		start := headerSize + i*(chunkSize+tagSize)
+		return sealed[start : start+chunkSize+tagSize]
+	}
+
+	swapped := bytes.Clone(sealed[:headerSize])
+	swapped = append(swapped, chunk(1)...)
+	swapped = append(swapped, chunk(0)...)
+	swapped = append(swapped, chunk(2)...)
+	if _, err := decrypt(key, swapped); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("swapped chunks: %v", err)
+	}
+
+	// The last chunk moved forward, with a full chunk after it.
+	moved := bytes.Clone(sealed[:headerSize])
+	moved = append(moved, chunk(0)...)
+	moved = append(moved, chunk(2)...)
+	moved = append(moved, chunk(1)...)
+	if _, err := decrypt(key, moved); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("moved last chunk: %v", err)
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	key := testKey(t, "secret")
+	sealed := encrypt(t, key, []byte("hello"))
+
+	if _, err := decrypt(testKey(t, "other"), sealed); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("other key: %v", err)
+	}
+	flipped := bytes.Clone(sealed)
+	flipped[len(flipped)-1] ^= 1
+	if _, err := decrypt(key, flipped); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("flipped bit: %v", err)
+	}
+	for _, plain := range []string{"", "TMD", "plain file contents"} {
+		if _, err := decrypt(key, []byte(plain)); !errors.Is(err, ErrNotEncrypted) {
+			t.Errorf("plaintext %q: %v", plain, err)
+		}
+	}
+}
+
+func TestIsEncrypted(t *testing.T) {
+	key := testKey(t, "secret")
+	tests := []struct {
+		data []byte
+		want bool
+	}{
+		{encrypt(t, key, nil), true},
+		{encrypt(t, key, []byte("hello")), true},
+		{[]byte("TMD"), false},
+		{[]byte("plain file contents"), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		got, err := IsEncrypted(bytes.NewReader(tt.data))
+		if err != nil || got != tt.want {
+			t.Errorf("IsEncrypted(%.8q) = %v, %v, want %v", tt.data, got, err, tt.want)
+		}
+	}
+}
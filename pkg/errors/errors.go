@@ -3,40 +3,111 @@ package errors
 import (
 	"errors"
 	"fmt"
-	"github.com/gotd/td/telegram/auth"
+	"time"
 
+	"github.com/gotd/td/telegram/auth"
 	"github.com/gotd/td/tgerr"
 )
 
 var (
 	ErrNumberNotSet  = errors.New("phone number is not set in cfg")
 	ErrCodeEmpty     = errors.New("the verification code cannot be empty")
-	ErrPasswordEmpty = errors.New("this account requires a 2FA password, but cfg is empty")
+	ErrPasswordEmpty = errors.New("this account requires a 2FA password, but none was provided")
+
+	ErrPhoneNumberInvalid    = errors.New("the phone number is invalid")
+	ErrPhoneNumberFlood      = errors.New("too many login attempts, please wait before trying again")
+	ErrPhoneNumberBanned     = errors.New("the phone number is banned from Telegram")
+	ErrPhoneNumberUnoccupied = errors.New("this phone number is not registered on Telegram; sign-up is required")
+	ErrPhoneCodeInvalid      = errors.New("the verification code you entered is invalid")
+	ErrPhoneCodeExpired      = errors.New("the verification code has expired; request a new one")
+	ErrPasswordInvalid       = errors.New("the 2FA password is invalid")
+	ErrSessionRevoked        = errors.New("the session was revoked from another device; log in again")
+	ErrAuthKeyUnregistered   = errors.New("the authorization key is not registered; log in again")
 )
 
+// FloodWaitError means Telegram asked us to back off for Wait before
+// repeating the request.
+type FloodWaitError struct {
+	Wait time.Duration
+	Err  error
+}
+
+func (e *FloodWaitError) Error() string {
+	return fmt.Sprintf("too many requests, retry in %s", e.Wait)
+}
+
+func (e *FloodWaitError) Unwrap() error {
+	return e.Err
+}
+
+var rpcErrors = map[string]error{
+	"PHONE_NUMBER_INVALID":    ErrPhoneNumberInvalid,
+	"PHONE_NUMBER_FLOOD":      ErrPhoneNumberFlood,
+	"PHONE_NUMBER_BANNED":     ErrPhoneNumberBanned,
+	"PHONE_NUMBER_UNOCCUPIED": ErrPhoneNumberUnoccupied,
+	"PHONE_CODE_INVALID":      ErrPhoneCodeInvalid,
+	"PHONE_CODE_EXPIRED":      ErrPhoneCodeExpired,
+	"PASSWORD_HASH_INVALID":   ErrPasswordInvalid,
+	"SESSION_REVOKED":         ErrSessionRevoked,
+	"SESSION_EXPIRED":         ErrSessionRevoked,
+	"AUTH_KEY_UNREGISTERED":   ErrAuthKeyUnregistered,
+}
+
+// HandleTGError maps Telegram RPC errors to the sentinel errors above (or a
+// *FloodWaitError), keeping the original error in the chain. Other errors are
+// returned unchanged.
 func HandleTGError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if wait, ok := tgerr.AsFloodWait(err); ok {
+		return &FloodWaitError{Wait: wait, Err: err}
+	}
 	var rpcErr *tgerr.Error
 	if errors.As(err, &rpcErr) {
-		switch rpcErr.Type {
-		case "PHONE_NUMBER_INVALID":
-			return fmt.Errorf("the phone number is invalid")
-		case "PHONE_NUMBER_FLOOD":
-			return fmt.Errorf("too many attempts, please wait before trying again")
-		case "PHONE_NUMBER_BANNED":
-			return fmt.Errorf("the phone number is banned from Telegram")
-		case "PHONE_CODE_INVALID":
-			return fmt.Errorf("the verification code you entered is invalid")
-		case "PHONE_CODE_EXPIRED":
-			return fmt.Errorf("the verification code has expired; request a new one")
-		case "PHONE_NUMBER_UNOCCUPIED":
-			return fmt.Errorf("this phone number is not registered on Telegram; sign-up is required")
-		default:
-			return fmt.Errorf("telegram RPC error: %s", rpcErr.Type)
+		if sentinel, ok := rpcErrors[rpcErr.Type]; ok {
+			return fmt.Errorf("%w: %w", sentinel, err)
+		}
+		return fmt.Errorf("telegram RPC error %s: %w", rpcErr.Type, err)
+	}
+	return err
+}
+
+// AsFloodWait reports how long to wait if err is a flood wait.
+func AsFloodWait(err error) (time.Duration, bool) {
+	var fw *FloodWaitError
+	if errors.As(err, &fw) {
+		return fw.Wait, true
+	}
+	return tgerr.AsFloodWait(err)
+}
+
+// IsReauthRequired reports whether the stored session is no longer usable and
+// a fresh login is needed.
+func IsReauthRequired(err error) bool {
+	return errors.Is(err, ErrSessionRevoked) ||
+		errors.Is(err, ErrAuthKeyUnregistered) ||
+		tgerr.Is(err, "SESSION_REVOKED", "SESSION_EXPIRED", "AUTH_KEY_UNREGISTERED")
+}
+
+// IsPermanent reports whether err can't be fixed by retrying, because the
+// configuration or the account itself has to change first.
+func IsPermanent(err error) bool {
+	for _, target := range []error{
+		ErrNumberNotSet,
+		ErrCodeEmpty,
+		ErrPasswordEmpty,
+		ErrPhoneNumberInvalid,
+		ErrPhoneNumberBanned,
+		ErrPhoneNumberUnoccupied,
+	} {
+		if errors.Is(err, target) {
+			return true
 		}
 	}
-	return nil
+	return false
 }
 
 func Is2FAError(err error) bool {
-	return errors.Is(err, auth.ErrPasswordAuthNeeded)
+	return errors.Is(err, auth.ErrPasswordAuthNeeded) || tgerr.Is(err, "SESSION_PASSWORD_NEEDED")
 }
@@ -0,0 +1,75 @@
+package errors
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gotd/td/tgerr"
+)
+
+func TestHandleTGError(t *testing.T) {
+	plain := errors.New("connection reset")
+	tests := []struct {
+		name     string
+		err      error
+		sentinel error
+		wait     time.Duration
+		reauth   bool
+		perm     bool
+	}{
+		{"invalid phone", tgerr.New(400, "PHONE_NUMBER_INVALID"), ErrPhoneNumberInvalid, 0, false, true},
+		{"phone flood", tgerr.New(400, "PHONE_NUMBER_FLOOD"), ErrPhoneNumberFlood, 0, false, false},
+		{"banned phone", tgerr.New(400, "PHONE_NUMBER_BANNED"), ErrPhoneNumberBanned, 0, false, true},
+		{"unregistered phone", tgerr.New(400, "PHONE_NUMBER_UNOCCUPIED"), ErrPhoneNumberUnoccupied, 0, false, true},
+		{"invalid code", tgerr.New(400, "PHONE_CODE_INVALID"), ErrPhoneCodeInvalid, 0, false, false},
+		{"expired code", tgerr.New(400, "PHONE_CODE_EXPIRED"), ErrPhoneCodeExpired, 0, false, false},
+		{"invalid password", tgerr.New(400, "PASSWORD_HASH_INVALID"), ErrPasswordInvalid, 0, false, false},
+		{"revoked session", tgerr.New(401, "SESSION_REVOKED"), ErrSessionRevoked, 0, true, false},
+		{"expired session", tgerr.New(401, "SESSION_EXPIRED"), ErrSessionRevoked, 0, true, false},
+		{"unregistered key", tgerr.New(401, "AUTH_KEY_UNREGISTERED"), ErrAuthKeyUnregistered, 0, true, false},
+		{"wrapped", fmt.Errorf("sign in: %w", tgerr.New(401, "AUTH_KEY_UNREGISTERED")), ErrAuthKeyUnregistered, 0, true, false},
+		{"flood wait", tgerr.New(420, "FLOOD_WAIT_30"), nil, 30 * time.Second, false, false},
+		{"unknown RPC error", tgerr.New(400, "CHANNEL_PRIVATE"), nil, 0, false, false},
+		{"not an RPC error", plain, nil, 0, false, false},
+		{"missing phone number", ErrNumberNotSet, ErrNumberNotSet, 0, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := HandleTGError(tt.err)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("%v lost the original error", err)
+			}
+			if tt.sentinel != nil && !errors.Is(err, tt.sentinel) {
+				t.Errorf("%v is not %v", err, tt.sentinel)
+			}
+			wait, ok := AsFloodWait(err)
+			if ok != (tt.wait > 0) || wait != tt.wait {
+				t.Errorf("AsFloodWait = %v, %v, want %v", wait, ok, tt.wait)
+			}
+			if tt.wait > 0 {
+				var fw *FloodWaitError
+				if !errors.As(err, &fw) {
+					t.Errorf("%v is not a *FloodWaitError", err)
+				}
+			}
+			if got := IsReauthRequired(err); got != tt.reauth {
+				t.Errorf("IsReauthRequired = %v, want %v", got, tt.reauth)
+			}
+			if got := IsReauthRequired(tt.err); got != tt.reauth {
+				t.Errorf("IsReauthRequired on the raw error = %v, want %v", got, tt.reauth)
+			}
+			if got := IsPermanent(err); got != tt.perm {
+				t.Errorf("IsPermanent = %v, want %v", got, tt.perm)
+			}
+		})
+	}
+
+	if err := HandleTGError(nil); err != nil {
+		t.Errorf("HandleTGError(nil) = %v", err)
+	}
+	if err := HandleTGError(plain); err != plain {
+		t.Errorf("HandleTGError changed a non-RPC error: %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package filehandler
 
 import (
 	"fmt"
+	"path"
 	"path/filepath"
 	"strings"
 
@@ -44,3 +45,25 @@ func (o *Organizer) getFilePath(mimeType, dialogName string) (string, error) {
 
 	return fullPath, nil
 }
+
+// Resolve maps a slash-separated object name, as built by BuildObjectName,
+// to a path below BaseDir. Names escaping BaseDir are rejected.
+func (o *Organizer) Resolve(objectName string) (string, error) {
+	clean := path.Clean("/" + objectName)
+	if clean == "/" || clean != "/"+objectName {
+		return "", fmt.Errorf("invalid object name %q", objectName)
+	}
+	return filepath.Join(o.BaseDir, filepath.FromSlash(clean)), nil
+}
+
+// FilePath resolves objectName like Resolve and creates its directory.
+func (o *Organizer) FilePath(objectName string) (string, error) {
+	fullPath, err := o.Resolve(objectName)
+	if err != nil {
+		return "", err
+	}
+	if err := ensureDir(filepath.Dir(fullPath)); err != nil {
+		return "", err
+	}
+	return fullPath, nil
+}
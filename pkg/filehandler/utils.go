@@ -53,6 +53,25 @@ func GetMimeType(media tg.MessageMediaClass) (string, error) {
 	}
 }
 
+// GetFileName returns the original file name of a document, or "" for photos
+// and documents sent without one.
+func GetFileName(media tg.MessageMediaClass) string {
+	m, ok := media.(*tg.MessageMediaDocument)
+	if !ok {
+		return ""
+	}
+	docObj, ok := m.Document.(*tg.Document)
+	if !ok || docObj == nil {
+		return ""
+	}
+	for _, attr := range docObj.Attributes {
+		if name, ok := attr.(*tg.DocumentAttributeFilename); ok {
+			return name.FileName
+		}
+	}
+	return ""
+}
+
 func ensureDir(path string) error {
 	err := os.MkdirAll(path, os.ModePerm)
 	if err != nil {
@@ -1,70 +1,170 @@
 package minio
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
 	"github.com/rs/zerolog/log"
+	"tmd/pkg/storage"
 )
 
 type Storage struct {
-	client   *minio.Client
-	bucket   string
-	basePath string
+	client       *minio.Client
+	bucket       string
+	basePath     string
+	region       string
+	scheme       string
+	storageClass string
+	sse          encrypt.ServerSide
+	versioning   bool
+	objectLock   bool
 }
 
-func NewStorage(endpoint, accessKey, secretKey, bucket, basePath string, useSSL bool) (*Storage, error) {
-	cli, err := minio.New(endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
-		Secure: useSSL,
+var _ storage.Storage = (*Storage)(nil)
+
+// Options describe the bucket to store media in. MinIO only needs the
+// endpoint, credentials and bucket; the rest tune AWS S3 and other
+// S3-compatible services such as Google Cloud Storage.
+type Options struct {
+	Endpoint  string
+	Region    string
+	AccessKey string
+	SecretKey string
+	Bucket    string
+	// BasePath prefixes every object key.
+	BasePath string
+	UseSSL   bool
+	// BucketLookup is auto, path or dns (virtual-host style).
+	BucketLookup string
+	StorageClass string
+	// SSE is empty, s3 (SSE-S3) or kms (SSE-KMS with KMSKeyID).
+	SSE      string
+	KMSKeyID string
+	// Scheme prefixes the media URLs recorded on messages, e.g. minio or s3.
+	Scheme string
+	// Versioning and ObjectLock are applied when Bootstrap creates the
+	// bucket. Object locking can only be enabled on new buckets.
+	Versioning bool
+	ObjectLock bool
+}
+
+var bucketLookups = map[string]minio.BucketLookupType{
+	"":     minio.BucketLookupAuto,
+	"auto": minio.BucketLookupAuto,
+	"path": minio.BucketLookupPath,
+	"dns":  minio.BucketLookupDNS,
+}
+
+func New(opts Options) (*Storage, error) {
+	// Without static keys, credentials come from the AWS environment
+	// variables, the shared credentials file or the instance's IAM role.
+	creds := credentials.NewStaticV4(opts.AccessKey, opts.SecretKey, "")
+	if opts.AccessKey == "" {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}},
+		})
+	}
+
+	lookup, ok := bucketLookups[opts.BucketLookup]
+	if !ok {
+		return nil, fmt.Errorf("unknown bucket lookup %q", opts.BucketLookup)
+	}
+
+	var sse encrypt.ServerSide
+	switch opts.SSE {
+	case "":
+	case "s3":
+		sse = encrypt.NewSSE()
+	case "kms":
+		var err error
+		if sse, err = encrypt.NewSSEKMS(opts.KMSKeyID, nil); err != nil {
+			return nil, fmt.Errorf("sse-kms: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown server-side encryption %q", opts.SSE)
+	}
+
+	cli, err := minio.New(opts.Endpoint, &minio.Options{
+		Creds:        creds,
+		Secure:       opts.UseSSL,
+		Region:       opts.Region,
+		BucketLookup: lookup,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("minio new client: %w", err)
 	}
 
+	scheme := opts.Scheme
+	if scheme == "" {
+		scheme = "minio"
+	}
 	return &Storage{
-		client:   cli,
-		bucket:   bucket,
-		basePath: basePath,
+		client:       cli,
+		bucket:       opts.Bucket,
+		basePath:     strings.Trim(opts.BasePath, "/"),
+		region:       opts.Region,
+		scheme:       scheme,
+		storageClass: opts.StorageClass,
+		sse:          sse,
+		versioning:   opts.Versioning,
+		objectLock:   opts.ObjectLock,
 	}, nil
 }
 
-func (m *Storage) StoreBytes(ctx context.Context, data []byte, mimeType, objectName string) (string, error) {
+// Put uploads the object below the base path. The returned media URL holds
+// the object name without the base path, as the API expects it.
+func (m *Storage) Put(ctx context.Context, objectName string, r io.Reader, size int64, opts storage.PutOptions) (string, error) {
 	log.Info().
 		Str("bucket", m.bucket).
-		Str("objectName", objectName).
-		Msg("Uploading memory data to MinIO")
+		Str("objectName", m.key(objectName)).
+		Msg("Uploading media to object storage")
 
-	reader := bytes.NewReader(data)
 	_, err := m.client.PutObject(
 		ctx,
 		m.bucket,
-		objectName,
-		reader,
-		int64(len(data)),
+		m.key(objectName),
+		r,
+		size,
 		minio.PutObjectOptions{
-			ContentType: mimeType,
+			ContentType:          opts.ContentType,
+			UserMetadata:         opts.Metadata,
+			StorageClass:         m.storageClass,
+			ServerSideEncryption: m.sse,
 		},
 	)
 	if err != nil {
 		return "", fmt.Errorf("minio put object: %w", err)
 	}
 
-	return fmt.Sprintf("minio://%s/%s", m.bucket, objectName), nil
+	return fmt.Sprintf("%s://%s/%s", m.scheme, m.bucket, objectName), nil
 }
 
-func (m *Storage) GeneratePresignedURL(objectName string, expiry time.Duration) (string, error) {
+func (m *Storage) PresignedURL(ctx context.Context, objectName string, expiry time.Duration) (string, error) {
 	reqParams := make(url.Values)
 
+	key := m.key(objectName)
+	if m.basePath != "" {
+		stat, err := m.stat(ctx, objectName)
+		if err != nil {
+			return "", err
+		}
+		key = stat.Key
+	}
 	presignedURL, err := m.client.PresignedGetObject(
-		context.Background(),
+		ctx,
 		m.bucket,
-		objectName,
+		key,
 		expiry,
 		reqParams,
 	)
@@ -73,3 +173,161 @@ func (m *Storage) GeneratePresignedURL(objectName string, expiry time.Duration)
 	}
 	return presignedURL.String(), nil
 }
+
+// Open returns a seekable reader over the object together with its metadata.
+// The caller must close the reader.
+func (m *Storage) Open(ctx context.Context, objectName string) (io.ReadSeekCloser, storage.ObjectInfo, error) {
+	obj, stat, err := m.open(ctx, m.key(objectName))
+	if errors.Is(err, storage.ErrObjectNotFound) && m.basePath != "" {
+		obj, stat, err = m.open(ctx, objectName)
+	}
+	if err != nil {
+		return nil, storage.ObjectInfo{}, err
+	}
+	return obj, objectInfo(stat), nil
+}
+
+func (m *Storage) open(ctx context.Context, key string) (*minio.Object, minio.ObjectInfo, error) {
+	obj, err := m.client.GetObject(ctx, m.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, minio.ObjectInfo{}, fmt.Errorf("minio get object: %w", err)
+	}
+
+	stat, err := obj.Stat()
+	if err != nil {
+		_ = obj.Close()
+		return nil, minio.ObjectInfo{}, statError(err)
+	}
+	return obj, stat, nil
+}
+
+func (m *Storage) Stat(ctx context.Context, objectName string) (storage.ObjectInfo, error) {
+	stat, err := m.stat(ctx, objectName)
+	if err != nil {
+		return storage.ObjectInfo{}, err
+	}
+	return objectInfo(stat), nil
+}
+
+// stat looks the object up below the base path and then, for media archived
+// before base_path was honoured, at the bucket root.
+func (m *Storage) stat(ctx context.Context, objectName string) (minio.ObjectInfo, error) {
+	stat, err := m.client.StatObject(ctx, m.bucket, m.key(objectName), minio.StatObjectOptions{})
+	if err != nil && minio.ToErrorResponse(err).Code == "NoSuchKey" && m.basePath != "" {
+		stat, err = m.client.StatObject(ctx, m.bucket, objectName, minio.StatObjectOptions{})
+	}
+	if err != nil {
+		return minio.ObjectInfo{}, statError(err)
+	}
+	return stat, nil
+}
+
+func (m *Storage) Delete(ctx context.Context, objectName string) error {
+	if err := m.client.RemoveObject(ctx, m.bucket, m.key(objectName), minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("minio remove object: %w", err)
+	}
+	return nil
+}
+
+// Walk lists the objects below the base path. Objects stored at the bucket
+// root before base_path was honoured are not listed, though Open and Stat
+// still find them.
+func (m *Storage) Walk(ctx context.Context, fn func(objectName string, info storage.ObjectInfo) error) error {
+	prefix := ""
+	if m.basePath != "" {
+		prefix = m.basePath + "/"
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for obj := range m.client.ListObjects(ctx, m.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return fmt.Errorf("minio list objects: %w", obj.Err)
+		}
+		if err := fn(strings.TrimPrefix(obj.Key, prefix), objectInfo(obj)); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// Check verifies that the configured bucket is reachable.
+func (m *Storage) Check(ctx context.Context) error {
+	exists, err := m.client.BucketExists(ctx, m.bucket)
+	if err != nil {
+		return fmt.Errorf("minio bucket exists: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("bucket %q does not exist", m.bucket)
+	}
+	return nil
+}
+
+// Bootstrap creates the bucket if it is missing, with versioning and object
+// locking as configured. On an existing bucket it enables versioning if asked
+// to, and only warns about object locking, which can't be turned on later.
+func (m *Storage) Bootstrap(ctx context.Context) error {
+	exists, err := m.client.BucketExists(ctx, m.bucket)
+	if err != nil {
+		return fmt.Errorf("minio bucket exists: %w", err)
+	}
+
+	if !exists {
+		err := m.client.MakeBucket(ctx, m.bucket, minio.MakeBucketOptions{
+			Region:        m.region,
+			ObjectLocking: m.objectLock,
+		})
+		if err != nil {
+			return fmt.Errorf("minio make bucket: %w", err)
+		}
+		log.Info().
+			Str("bucket", m.bucket).
+			Bool("object_lock", m.objectLock).
+			Msg("Created bucket")
+	} else if m.objectLock {
+		if _, _, _, _, err := m.client.GetObjectLockConfig(ctx, m.bucket); err != nil {
+			log.Warn().Err(err).Str("bucket", m.bucket).Msg("Object locking was requested but is not enabled on the existing bucket")
+		}
+	}
+
+	if !m.versioning || m.objectLock {
+		// Object locking enables versioning by itself.
+		return nil
+	}
+	versioning, err := m.client.GetBucketVersioning(ctx, m.bucket)
+	if err != nil {
+		return fmt.Errorf("minio get bucket versioning: %w", err)
+	}
+	if versioning.Enabled() {
+		return nil
+	}
+	if err := m.client.EnableVersioning(ctx, m.bucket); err != nil {
+		return fmt.Errorf("minio enable versioning: %w", err)
+	}
+	log.Info().Str("bucket", m.bucket).Msg("Enabled bucket versioning")
+	return nil
+}
+
+// key returns the bucket key of objectName.
+func (m *Storage) key(objectName string) string {
+	if m.basePath == "" {
+		return objectName
+	}
+	return m.basePath + "/" + objectName
+}
+
+func objectInfo(stat minio.ObjectInfo) storage.ObjectInfo {
+	return storage.ObjectInfo{
+		Size:         stat.Size,
+		ContentType:  stat.ContentType,
+		ETag:         stat.ETag,
+		LastModified: stat.LastModified,
+		Metadata:     stat.UserMetadata,
+	}
+}
+
+func statError(err error) error {
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return storage.ErrObjectNotFound
+	}
+	return fmt.Errorf("minio stat object: %w", err)
+}
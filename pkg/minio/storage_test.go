@@ -0,0 +1,193 @@
+package minio
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"tmd/pkg/storage"
+)
+
+// newTestServer starts an in-memory S3 server and returns its endpoint.
+func newTestServer(t *testing.T) string {
+	t.Helper()
+	srv := httptest.NewServer(gofakes3.New(s3mem.New()).Server())
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+func testOptions(endpoint, basePath string) Options {
+	return Options{
+		Endpoint:     endpoint,
+		Region:       "us-east-1",
+		AccessKey:    "access",
+		SecretKey:    "secret",
+		Bucket:       "tmd",
+		BasePath:     basePath,
+		BucketLookup: "path",
+	}
+}
+
+// newTestStorage starts an in-memory S3 server and returns a bootstrapped
+// storage on it for every base path given.
+func newTestStorage(t *testing.T, basePaths ...string) []*Storage {
+	t.Helper()
+	endpoint := newTestServer(t)
+
+	stores := make([]*Storage, len(basePaths))
+	for i, basePath := range basePaths {
+		st, err := New(testOptions(endpoint, basePath))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := st.Bootstrap(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		stores[i] = st
+	}
+	return stores
+}
+
+func put(t *testing.T, st *Storage, objectName, content string) string {
+	t.Helper()
+	mediaURL, err := st.Put(context.Background(), objectName, strings.NewReader(content), int64(len(content)), storage.PutOptions{
+		ContentType: "image/jpeg",
+		Metadata:    map[string]string{storage.MetaSHA256: "abc"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mediaURL
+}
+
+func readAll(t *testing.T, st *Storage, objectName string) string {
+	t.Helper()
+	r, _, err := st.Open(context.Background(), objectName)
+	if err != nil {
+		t.Fatalf("open %s: %v", objectName, err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestBasePathPrefixesKeys(t *testing.T) {
+	stores := newTestStorage(t, "/me/", "")
+	st, root := stores[0], stores[1]
+
+	mediaURL := put(t, st, "chat/photo/1.jpg", "hello")
+	if mediaURL != "minio://tmd/chat/photo/1.jpg" {
+		t.Errorf("media URL = %q", mediaURL)
+	}
+	if got := readAll(t, root, "me/chat/photo/1.jpg"); got != "hello" {
+		t.Errorf("object below base path = %q", got)
+	}
+	info, err := st.Stat(context.Background(), "chat/photo/1.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != 5 || info.Metadata[storage.MetaSHA256] != "abc" {
+		t.Errorf("stat = %+v", info)
+	}
+}
+
+func TestLegacyObjectsWithoutBasePath(t *testing.T) {
+	stores := newTestStorage(t, "me", "")
+	st, root := stores[0], stores[1]
+	ctx := context.Background()
+
+	// Archived before base_path was honoured.
+	put(t, root, "chat/photo/2.jpg", "legacy")
+	put(t, st, "chat/photo/3.jpg", "new")
+
+	if got := readAll(t, st, "chat/photo/2.jpg"); got != "legacy" {
+		t.Errorf("legacy object = %q", got)
+	}
+	if info, err := st.Stat(ctx, "chat/photo/2.jpg"); err != nil || info.Size != 6 {
+		t.Errorf("stat legacy object = %+v, %v", info, err)
+	}
+	url, err := st.PresignedURL(ctx, "chat/photo/2.jpg", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(url, "/tmd/chat/photo/2.jpg") {
+		t.Errorf("presigned URL of legacy object = %q", url)
+	}
+
+	if _, err := st.Stat(ctx, "chat/photo/4.jpg"); !errors.Is(err, storage.ErrObjectNotFound) {
+		t.Errorf("stat missing object: %v", err)
+	}
+	if _, _, err := st.Open(ctx, "chat/photo/4.jpg"); !errors.Is(err, storage.ErrObjectNotFound) {
+		t.Errorf("open missing object: %v", err)
+	}
+
+	var listed []string
+	if err := st.Walk(ctx, func(objectName string, _ storage.ObjectInfo) error {
+		listed = append(listed, objectName)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0] != "chat/photo/3.jpg" {
+		t.Errorf("walk = %v", listed)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	opts := testOptions(newTestServer(t), "")
+	opts.Scheme = "s3"
+	opts.Versioning = true
+	st, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := st.Check(ctx); err == nil {
+		t.Fatal("check passed before the bucket was created")
+	}
+	for i := 0; i < 2; i++ {
+		if err := st.Bootstrap(ctx); err != nil {
+			t.Fatalf("bootstrap %d: %v", i, err)
+		}
+	}
+	if err := st.Check(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if mediaURL := put(t, st, "chat/photo/1.jpg", "hello world"); mediaURL != "s3://tmd/chat/photo/1.jpg" {
+		t.Errorf("media URL = %q", mediaURL)
+	}
+
+	r, info, err := st.Open(ctx, "chat/photo/1.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Seek(6, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "world" || info.Size != 11 || info.ContentType != "image/jpeg" {
+		t.Errorf("open = %q, %+v", data, info)
+	}
+
+	if err := st.Delete(ctx, "chat/photo/1.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Stat(ctx, "chat/photo/1.jpg"); !errors.Is(err, storage.ErrObjectNotFound) {
+		t.Errorf("stat after delete: %v", err)
+	}
+}
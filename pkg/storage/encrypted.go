@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"time"
+
+	"tmd/pkg/envelope"
+)
+
+// MetaEncryption marks objects written by Encrypted.
+const MetaEncryption = "Encryption"
+
+const encryptionScheme = "tmd-envelope-v1"
+
+// Encrypted decrypts objects when they are opened and, with seal set,
+// encrypts new objects with a fresh data key each before handing them to the
+// underlying storage. Objects stored without encryption are returned as they
+// are.
+type Encrypted struct {
+	Storage
+	key  *envelope.MasterKey
+	seal bool
+}
+
+func NewEncrypted(st Storage, key *envelope.MasterKey, seal bool) *Encrypted {
+	return &Encrypted{Storage: st, key: key, seal: seal}
+}
+
+// Put drops the original file name from the metadata, as it may be as
+// sensitive as the file itself.
+func (e *Encrypted) Put(ctx context.Context, objectName string, r io.Reader, size int64, opts PutOptions) (string, error) {
+	if !e.seal {
+		return e.Storage.Put(ctx, objectName, r, size, opts)
+	}
+	sealed, err := e.key.Encrypt(r, size)
+	if err != nil {
+		return "", err
+	}
+	opts.Metadata = maps.Clone(opts.Metadata)
+	if opts.Metadata == nil {
+		opts.Metadata = make(map[string]string)
+	}
+	delete(opts.Metadata, MetaFilename)
+	opts.Metadata[MetaEncryption] = encryptionScheme
+	return e.Storage.Put(ctx, objectName, sealed, envelope.EncryptedSize(size), opts)
+}
+
+func (e *Encrypted) Open(ctx context.Context, objectName string) (io.ReadSeekCloser, ObjectInfo, error) {
+	obj, info, err := e.Storage.Open(ctx, objectName)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	plain, size, err := e.key.Decrypt(obj, info.Size)
+	if errors.Is(err, envelope.ErrNotEncrypted) {
+		if _, err := obj.Seek(0, io.SeekStart); err != nil {
+			_ = obj.Close()
+			return nil, ObjectInfo{}, err
+		}
+		return obj, info, nil
+	}
+	if err != nil {
+		_ = obj.Close()
+		return nil, ObjectInfo{}, fmt.Errorf("decrypt %s: %w", objectName, err)
+	}
+	info.Size = size
+	return struct {
+		io.ReadSeeker
+		io.Closer
+	}{plain, obj}, info, nil
+}
+
+func (e *Encrypted) Stat(ctx context.Context, objectName string) (ObjectInfo, error) {
+	info, err := e.Storage.Stat(ctx, objectName)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return e.plainInfo(ctx, objectName, info)
+}
+
+// Walk reports plaintext sizes. Listings carry no metadata, so every object
+// is looked up as in Stat.
+func (e *Encrypted) Walk(ctx context.Context, fn func(objectName string, info ObjectInfo) error) error {
+	return e.Storage.Walk(ctx, func(objectName string, info ObjectInfo) error {
+		if info.Metadata == nil {
+			stat, err := e.Storage.Stat(ctx, objectName)
+			if errors.Is(err, ErrObjectNotFound) {
+				// Deleted since it was listed.
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			info.Metadata = stat.Metadata
+		}
+		info, err := e.plainInfo(ctx, objectName, info)
+		if errors.Is(err, ErrObjectNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return fn(objectName, info)
+	})
+}
+
+// plainInfo replaces the size of an encrypted object with that of its
+// plaintext. Objects are recognized by their metadata or, on backends without
+// any, by their header. A truncated object keeps its stored size, so size
+// checks flag it.
+func (e *Encrypted) plainInfo(ctx context.Context, objectName string, info ObjectInfo) (ObjectInfo, error) {
+	encrypted := info.Metadata[MetaEncryption] == encryptionScheme
+	if info.Metadata == nil {
+		obj, _, err := e.Storage.Open(ctx, objectName)
+		if err != nil {
+			return ObjectInfo{}, err
+		}
+		encrypted, err = envelope.IsEncrypted(obj)
+		_ = obj.Close()
+		if err != nil {
+			return ObjectInfo{}, fmt.Errorf("read %s: %w", objectName, err)
+		}
+	}
+	if !encrypted {
+		return info, nil
+	}
+	if size, err := envelope.PlainSize(info.Size); err == nil {
+		info.Size = size
+	}
+	return info, nil
+}
+
+// PresignedURL is unsupported: clients could download ciphertext they can't
+// decrypt.
+func (e *Encrypted) PresignedURL(context.Context, string, time.Duration) (string, error) {
+	return "", ErrPresignUnsupported
+}
+
+// Bootstrap passes through to the underlying storage.
+func (e *Encrypted) Bootstrap(ctx context.Context) error {
+	if b, ok := e.Storage.(Bootstrapper); ok {
+		return b.Bootstrap(ctx)
+	}
+	return nil
+}
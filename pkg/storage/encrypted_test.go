@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"tmd/pkg/envelope"
+)
+
+func TestEncryptedSizes(t *testing.T) {
+	ctx := context.Background()
+	local, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := envelope.LoadMasterKey("secret", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := NewEncrypted(local, key, true)
+
+	content := "hello world"
+	if _, err := st.Put(ctx, "chat/photo/1.jpg", strings.NewReader(content), int64(len(content)), PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// Stored before encryption was enabled, with a size an encrypted object
+	// could have.
+	plain := strings.Repeat("x", int(envelope.EncryptedSize(100)))
+	if _, err := local.Put(ctx, "chat/photo/2.jpg", strings.NewReader(plain), int64(len(plain)), PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int64{
+		"chat/photo/1.jpg": int64(len(content)),
+		"chat/photo/2.jpg": int64(len(plain)),
+	}
+
+	for objectName, size := range want {
+		info, err := st.Stat(ctx, objectName)
+		if err != nil || info.Size != size {
+			t.Errorf("stat %s = %+v, %v, want size %d", objectName, info, err, size)
+		}
+
+		r, info, err := st.Open(ctx, objectName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil || int64(len(data)) != size || info.Size != size {
+			t.Errorf("open %s = %d bytes, %+v, %v, want %d", objectName, len(data), info, err, size)
+		}
+	}
+
+	walked := 0
+	if err := st.Walk(ctx, func(objectName string, info ObjectInfo) error {
+		walked++
+		if info.Size != want[objectName] {
+			t.Errorf("walk %s: size %d, want %d", objectName, info.Size, want[objectName])
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if walked != len(want) {
+		t.Errorf("walked %d objects, want %d", walked, len(want))
+	}
+}
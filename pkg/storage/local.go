@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"tmd/pkg/filehandler"
+)
+
+// Local keeps media as plain files below a base directory, laid out by the
+// filehandler.Organizer as <dialog>/<mime>/<message id>.<ext>.
+type Local struct {
+	organizer *filehandler.Organizer
+}
+
+func NewLocal(baseDir string) (*Local, error) {
+	if err := os.MkdirAll(baseDir, 0o755); err != nil {
+		return nil, fmt.Errorf("create storage directory: %w", err)
+	}
+	return &Local{organizer: filehandler.NewOrganizer(baseDir)}, nil
+}
+
+// Put writes to a temporary file first, so readers never see a partial file.
+// Plain files carry no metadata, so opts.Metadata is dropped.
+func (l *Local) Put(_ context.Context, objectName string, r io.Reader, size int64, _ PutOptions) (string, error) {
+	filePath, err := l.organizer.FilePath(objectName)
+	if err != nil {
+		return "", err
+	}
+
+	log.Info().
+		Str("path", filePath).
+		Msg("Writing media to disk")
+
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), ".upload-*")
+	if err != nil {
+		return "", fmt.Errorf("create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, r)
+	if err == nil && written != size {
+		err = fmt.Errorf("wrote %d of %d bytes", written, size)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("write %s: %w", objectName, err)
+	}
+	if err := os.Rename(tmp.Name(), filePath); err != nil {
+		return "", fmt.Errorf("move %s into place: %w", objectName, err)
+	}
+
+	return "file://" + objectName, nil
+}
+
+func (l *Local) Open(_ context.Context, objectName string) (io.ReadSeekCloser, ObjectInfo, error) {
+	filePath, err := l.organizer.Resolve(objectName)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, ObjectInfo{}, localError(err)
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, ObjectInfo{}, localError(err)
+	}
+	return f, fileInfo(objectName, stat), nil
+}
+
+func (l *Local) Stat(_ context.Context, objectName string) (ObjectInfo, error) {
+	filePath, err := l.organizer.Resolve(objectName)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return ObjectInfo{}, localError(err)
+	}
+	return fileInfo(objectName, stat), nil
+}
+
+func (l *Local) Delete(_ context.Context, objectName string) error {
+	filePath, err := l.organizer.Resolve(objectName)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filePath); err != nil {
+		return localError(err)
+	}
+	return nil
+}
+
+// Walk skips the temporary files of uploads in progress.
+func (l *Local) Walk(ctx context.Context, fn func(objectName string, info ObjectInfo) error) error {
+	return filepath.WalkDir(l.organizer.BaseDir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			return nil
+		}
+		stat, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(l.organizer.BaseDir, filePath)
+		if err != nil {
+			return err
+		}
+		objectName := filepath.ToSlash(rel)
+		return fn(objectName, fileInfo(objectName, stat))
+	})
+}
+
+func (l *Local) PresignedURL(context.Context, string, time.Duration) (string, error) {
+	return "", ErrPresignUnsupported
+}
+
+func (l *Local) Check(context.Context) error {
+	probe, err := os.CreateTemp(l.organizer.BaseDir, ".check-*")
+	if err != nil {
+		return fmt.Errorf("storage directory is not writable: %w", err)
+	}
+	_ = probe.Close()
+	return os.Remove(probe.Name())
+}
+
+func fileInfo(objectName string, stat fs.FileInfo) ObjectInfo {
+	return ObjectInfo{
+		Size:         stat.Size(),
+		ContentType:  mime.TypeByExtension(path.Ext(objectName)),
+		ETag:         strconv.FormatInt(stat.ModTime().UnixNano(), 36) + "-" + strconv.FormatInt(stat.Size(), 36),
+		LastModified: stat.ModTime(),
+	}
+}
+
+func localError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrObjectNotFound
+	}
+	return err
+}
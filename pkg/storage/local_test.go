@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	st, err := NewLocal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Check(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	mediaURL, err := st.Put(ctx, "chat/photo/1.jpg", strings.NewReader("hello world"), 11, PutOptions{ContentType: "image/jpeg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mediaURL != "file://chat/photo/1.jpg" {
+		t.Errorf("media URL = %q", mediaURL)
+	}
+
+	r, info, err := st.Open(ctx, "chat/photo/1.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Seek(6, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "world" || info.Size != 11 || info.ContentType != "image/jpeg" {
+		t.Errorf("open = %q, %+v", data, info)
+	}
+
+	// Leftovers of interrupted uploads are not objects.
+	if err := os.WriteFile(filepath.Join(dir, "chat", "photo", ".upload-1"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var listed []string
+	if err := st.Walk(ctx, func(objectName string, info ObjectInfo) error {
+		listed = append(listed, objectName)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0] != "chat/photo/1.jpg" {
+		t.Errorf("walk = %v", listed)
+	}
+
+	if err := st.Delete(ctx, "chat/photo/1.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Stat(ctx, "chat/photo/1.jpg"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("stat after delete: %v", err)
+	}
+	if _, _, err := st.Open(ctx, "chat/photo/1.jpg"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("open after delete: %v", err)
+	}
+	if _, err := st.PresignedURL(ctx, "chat/photo/1.jpg", 0); !errors.Is(err, ErrPresignUnsupported) {
+		t.Errorf("presigned URL: %v", err)
+	}
+}
+
+func TestLocalRejectsShortWrites(t *testing.T) {
+	st, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := st.Put(ctx, "chat/photo/1.jpg", strings.NewReader("short"), 10, PutOptions{}); err == nil {
+		t.Fatal("put of a short reader succeeded")
+	}
+	if _, err := st.Stat(ctx, "chat/photo/1.jpg"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("stat after failed put: %v", err)
+	}
+}
+
+func TestLocalRejectsEscapingNames(t *testing.T) {
+	st, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, name := range []string{"../outside.jpg", "chat/../../outside.jpg", "/abs.jpg", ""} {
+		if _, err := st.Put(ctx, name, strings.NewReader("x"), 1, PutOptions{}); err == nil {
+			t.Errorf("put %q succeeded", name)
+		}
+		if _, _, err := st.Open(ctx, name); err == nil {
+			t.Errorf("open %q succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+var (
+	ErrObjectNotFound = errors.New("object not found")
+	// ErrPresignUnsupported is returned by backends that can't hand out
+	// direct links; their objects are streamed through the API instead.
+	ErrPresignUnsupported = errors.New("presigned URLs are not supported by this storage backend")
+)
+
+// Keys of the metadata attached to archived media.
+const (
+	MetaChatID       = "Chat-Id"
+	MetaMessageID    = "Message-Id"
+	MetaSenderID     = "Sender-Id"
+	MetaSHA256       = "Sha256"
+	MetaFilename     = "Filename"
+	MetaTelegramDate = "Telegram-Date"
+	// MetaSize is the size of the media as downloaded, before encryption.
+	MetaSize = "Size"
+)
+
+// Storage keeps archived media. Object names are slash-separated paths such as
+// those built by filehandler.BuildObjectName.
+type Storage interface {
+	// Put stores size bytes from r under objectName and returns the media URL
+	// recorded on the message.
+	Put(ctx context.Context, objectName string, r io.Reader, size int64, opts PutOptions) (string, error)
+	// Open returns a seekable reader over the object, so callers can serve
+	// byte ranges, together with its metadata. The caller must close it.
+	Open(ctx context.Context, objectName string) (io.ReadSeekCloser, ObjectInfo, error)
+	Stat(ctx context.Context, objectName string) (ObjectInfo, error)
+	Delete(ctx context.Context, objectName string) error
+	// PresignedURL returns a short-lived direct link to the object, or
+	// ErrPresignUnsupported.
+	PresignedURL(ctx context.Context, objectName string, expiry time.Duration) (string, error)
+	// Walk calls fn for every stored object, stopping at the first error.
+	// The metadata of listed objects is not filled in.
+	Walk(ctx context.Context, fn func(objectName string, info ObjectInfo) error) error
+	// Check verifies that the backend is reachable and writable.
+	Check(ctx context.Context) error
+}
+
+// ObjectName returns the object name recorded in a media URL returned by
+// Put, such as minio://bucket/name or file://name.
+func ObjectName(mediaURL string) (string, bool) {
+	scheme, rest, ok := strings.Cut(mediaURL, "://")
+	if !ok {
+		return "", false
+	}
+	if scheme != "file" {
+		// Drop the bucket.
+		_, rest, ok = strings.Cut(rest, "/")
+	}
+	return rest, ok && rest != ""
+}
+
+// Bootstrapper is implemented by backends that can create what they store
+// into, such as a missing bucket.
+type Bootstrapper interface {
+	Bootstrap(ctx context.Context) error
+}
+
+// PutOptions describe an object being stored.
+type PutOptions struct {
+	ContentType string
+	// Metadata is attached to the object where the backend supports it, so
+	// objects describe themselves without the database. Values must be ASCII.
+	Metadata map[string]string
+}
+
+type ObjectInfo struct {
+	Size         int64
+	ContentType  string
+	ETag         string
+	LastModified time.Time
+	Metadata     map[string]string
+}
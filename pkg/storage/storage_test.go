@@ -0,0 +1,26 @@
+package storage
+
+import "testing"
+
+func TestObjectName(t *testing.T) {
+	tests := []struct {
+		mediaURL string
+		want     string
+		ok       bool
+	}{
+		{"minio://tmd/chat/photo/1.jpg", "chat/photo/1.jpg", true},
+		{"s3://archive/support-1/chat/photo/1.jpg", "support-1/chat/photo/1.jpg", true},
+		{"file://chat/photo/1.jpg", "chat/photo/1.jpg", true},
+		{"minio://tmd", "", false},
+		{"minio://tmd/", "", false},
+		{"file://", "", false},
+		{"chat/photo/1.jpg", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := ObjectName(tt.mediaURL)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("ObjectName(%q) = %q, %v, want %q, %v", tt.mediaURL, got, ok, tt.want, tt.ok)
+		}
+	}
+}
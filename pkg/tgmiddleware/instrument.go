@@ -0,0 +1,41 @@
+package tgmiddleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// Instrument records the latency of every request, and logs and counts the
+// flood waits Telegram answers with, labelled by method.
+func Instrument(duration *prometheus.HistogramVec, floodWaits *prometheus.CounterVec) telegram.Middleware {
+	return telegram.MiddlewareFunc(func(next tg.Invoker) telegram.InvokeFunc {
+		return func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+			method := requestName(input)
+			start := time.Now()
+			err := next.Invoke(ctx, input, output)
+			duration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+			if wait, ok := tgerr.AsFloodWait(err); ok {
+				floodWaits.WithLabelValues(method).Inc()
+				log.Warn().
+					Str("request", method).
+					Dur("retry_after", wait).
+					Msg("Telegram flood wait")
+			}
+			return err
+		}
+	})
+}
+
+func requestName(input bin.Encoder) string {
+	if o, ok := input.(interface{ TypeName() string }); ok {
+		return o.TypeName()
+	}
+	return "unknown"
+}
@@ -0,0 +1,37 @@
+package tgmiddleware
+
+import (
+	"context"
+
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"golang.org/x/time/rate"
+)
+
+// RateLimit paces requests with token buckets. File downloads are paced by
+// their own limiter so a large media backlog doesn't starve history
+// requests, and the other way round.
+func RateLimit(requests, downloads *rate.Limiter) telegram.Middleware {
+	return telegram.MiddlewareFunc(func(next tg.Invoker) telegram.InvokeFunc {
+		return func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+			limiter := requests
+			if isDownload(input) {
+				limiter = downloads
+			}
+			if err := limiter.Wait(ctx); err != nil {
+				return err
+			}
+			return next.Invoke(ctx, input, output)
+		}
+	})
+}
+
+func isDownload(input bin.Encoder) bool {
+	switch input.(type) {
+	case *tg.UploadGetFileRequest, *tg.UploadGetCDNFileRequest:
+		return true
+	default:
+		return false
+	}
+}